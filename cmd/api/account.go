@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// showCurrentUserHandler returns the profile of the authenticated user.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCurrentUserHandler changes the name of the authenticated user. The email
+// address and password have their own endpoints, as changing them takes more
+// than a single request.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkCurrentPassword checks the password that the authenticated user gave to
+// confirm a change to their account, sending an error response and returning
+// false if it is wrong. Wrong passwords count towards the login lockout, so that
+// a stolen session can't be used to guess the password.
+func (app *application) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user *data.User,
+	plaintextPassword string) bool {
+
+	if !app.checkLoginLockout(w, r, user.Email) {
+		return false
+	}
+
+	match, err := user.Password.Matches(plaintextPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !match {
+		err = app.recordFailedLogin(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+
+		v := validator.New()
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+// changePasswordHandler sets a new password for the authenticated user, given
+// their current one. Every other session of the user is revoked, along with any
+// outstanding password reset tokens.
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.Password)
+	app.passwordPolicy.Validate(v, input.Password, user.Name, user.Email)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	sessionID, err := app.currentSessionID(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteOtherSessions(r.Context(), user.ID, sessionID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully changed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createEmailChangeHandler starts changing the authenticated user's email
+// address, given their password. A confirmation token is emailed to the new
+// address, and the address on the account only changes once the token is
+// sent back to updateUserEmailHandler.
+func (app *application) createEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(!strings.EqualFold(input.Email, user.Email), "email", "must be different from your current email address")
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, input.Password) {
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(r.Context(), input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.EmailChanges.New(r.Context(), user.ID, input.Email, 24*time.Hour)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"emailChangeToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(input.Email, "email_change.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the new address containing confirmation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserEmailHandler confirms an email change with the token sent to the new
+// address, and switches the user's email to it. The old address is told about
+// the change.
+func (app *application) updateUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.VaidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, email, err := app.models.EmailChanges.GetForToken(r.Context(), input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = email
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Password reset tokens were sent to the old address, so they are revoked
+	// along with the email change tokens.
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopePasswordReset} {
+		err = app.models.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"newEmail": user.Email,
+		}
+
+		err := app.mailer.Send(oldEmail, "email_changed.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
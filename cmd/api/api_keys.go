@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+// createAPIKeyHandler creates an API key for the authenticated user. The key can
+// only be granted permissions which the user holds, and the plaintext key is only
+// ever returned in this response.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	key := &data.APIKey{
+		UserID:      user.ID,
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, code := range key.Permissions {
+		v.Check(permissions.Include(code), "permissions", fmt.Sprintf("must only contain permissions you hold (%q is not one of them)", code))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err = app.models.APIKeys.New(r.Context(), user.ID, key.Name, key.Permissions, key.Expiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/api-keys/%d", key.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAPIKeysHandler returns the API keys of the authenticated user.
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAPIKeyHandler revokes one of the authenticated user's API keys.
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.APIKeys.Delete(r.Context(), user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
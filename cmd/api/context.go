@@ -3,12 +3,19 @@ package main
 import (
 	"context"
 	"greenlight/internal/data"
+	"greenlight/internal/jwt"
 	"net/http"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey       = contextKey("user")
+	tokenContextKey      = contextKey("token")
+	claimsContextKey     = contextKey("claims")
+	apiKeyContextKey     = contextKey("apiKey")
+	membershipContextKey = contextKey("membership")
+)
 
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant
@@ -27,3 +34,58 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetToken stores the plaintext bearer token that the request was
+// authenticated with, so that handlers such as the logout handler can act on it.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken returns the plaintext bearer token for the request, or the
+// empty string if the request was made anonymously.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// contextSetClaims stores the claims of the signed access token that the request
+// was authenticated with.
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims returns the claims of the signed access token for the request,
+// or nil if the request wasn't authenticated with a signed token.
+func (app *application) contextGetClaims(r *http.Request) *jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims
+}
+
+// contextSetAPIKey stores the API key that the request was authenticated with.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key for the request, or nil if the request
+// wasn't authenticated with an API key.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetMembership stores the membership of the user in the organization
+// whose catalog the request works on.
+func (app *application) contextSetMembership(r *http.Request, membership *data.Membership) *http.Request {
+	ctx := context.WithValue(r.Context(), membershipContextKey, membership)
+	return r.WithContext(ctx)
+}
+
+// contextGetMembership returns the membership stored by requireOrganizationRole,
+// or nil if the request works on the shared catalog.
+func (app *application) contextGetMembership(r *http.Request) *data.Membership {
+	membership, _ := r.Context().Value(membershipContextKey).(*data.Membership)
+	return membership
+}
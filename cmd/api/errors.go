@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError logs the given error using the application logger.
@@ -92,3 +95,50 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) notOwnerResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you can only change movies that you created"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notMemberResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you are not a member of the requested organization"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) lastAdminResponse(w http.ResponseWriter, r *http.Request) {
+	message := "an organization must keep at least one admin"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) mfaUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is not available on this server"
+	app.errorResponse(w, r, http.StatusNotImplemented, message)
+}
+
+func (app *application) mfaEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled for your account"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) mfaNotEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is not enabled for your account"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) defaultRoleResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the default role for new users can't be renamed or deleted"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"greenlight/internal/data"
 	"greenlight/internal/validator"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -160,6 +162,61 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int,
 	return i
 }
 
+// readBool reads an optional boolean query string value. It returns nil if the
+// key isn't present, so that callers can tell "not given" apart from false.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// readBound reads an optional integer query string value which can be given
+// under either of two keys, such as year_min or year_gte, but not both.
+func (app *application) readBound(qs url.Values, key, alias string, v *validator.Validator) int {
+	if !qs.Has(alias) {
+		return app.readInt(qs, key, 0, v)
+	}
+
+	if qs.Has(key) {
+		v.AddError(alias, "must not be given together with "+key)
+	}
+
+	return app.readInt(qs, alias, 0, v)
+}
+
+// readTime reads an optional query string value holding an RFC 3339 timestamp
+// or a date such as 2006-01-02, which is taken as midnight UTC. It returns the
+// zero time if the key isn't present, and reports whether a date was given.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) (time.Time, bool) {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}, false
+	}
+
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, true
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp or a date (YYYY-MM-DD)")
+		return time.Time{}, false
+	}
+
+	return t, false
+}
+
 // The beckground() helper accepts an arbitrary function as a parameter
 func (app *application) background(fn func()) {
 	// Implement the WaitGroup counter.
@@ -181,3 +238,41 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// permissionsForRequest returns the permissions that apply to the current request.
+// For a signed access token these are the permissions it carries, otherwise they
+// are read from the database. A request made with an API key only gets the
+// permissions listed on the key which the user still holds.
+func (app *application) permissionsForRequest(r *http.Request) (data.Permissions, error) {
+	if claims := app.contextGetClaims(r); claims != nil {
+		return data.Permissions(claims.Permissions), nil
+	}
+
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if key := app.contextGetAPIKey(r); key != nil {
+		permissions = permissions.Intersect(key.Permissions)
+	}
+
+	return permissions, nil
+}
+
+// currentUser returns the full record of the authenticated user. The user stored
+// in the request context is complete, except when the request was made with a
+// signed access token, which only carries the user's ID and activation status.
+// It is only called by handlers which need the user's stored details, such as
+// their email address, so authorizing a request never needs the lookup.
+func (app *application) currentUser(r *http.Request) (*data.User, error) {
+	user := app.contextGetUser(r)
+
+	if app.contextGetClaims(r) == nil {
+		return user, nil
+	}
+
+	return app.models.Users.Get(r.Context(), user.ID)
+}
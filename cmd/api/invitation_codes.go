@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// invitationCodeTTL is how long an invitation code is valid for when no expiry
+// is given.
+const invitationCodeTTL = 7 * 24 * time.Hour
+
+// emailDomainAllowed reports whether the domain of an email address is one of
+// the domains allowed to register. Subdomains have to be allowed separately.
+func (app *application) emailDomainAllowed(email string) bool {
+	i := strings.LastIndexByte(email, '@')
+	if i < 0 {
+		return false
+	}
+
+	domain := strings.ToLower(email[i+1:])
+
+	for _, allowed := range app.config.registration.allowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createInvitationCodeHandler creates an invitation code which can be used to
+// register a limited number of times, optionally only by the given email
+// address. The plaintext code is only ever returned in this response.
+func (app *application) createInvitationCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string     `json:"email"`
+		MaxUses     *int       `json:"max_uses"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	code := &data.InvitationCode{
+		Email:       input.Email,
+		MaxUses:     1,
+		Permissions: input.Permissions,
+		CreatedBy:   &user.ID,
+		Expiry:      time.Now().Add(invitationCodeTTL),
+	}
+
+	if input.MaxUses != nil {
+		code.MaxUses = *input.MaxUses
+	}
+
+	if input.Expiry != nil {
+		code.Expiry = *input.Expiry
+	}
+
+	if code.Permissions == nil {
+		code.Permissions = data.Permissions{}
+	}
+
+	v := validator.New()
+
+	data.ValidateInvitationCode(v, code)
+
+	err = app.checkPermissionCodes(r.Context(), v, code.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.InvitationCodes.New(r.Context(), code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/invitation-codes/%d", code.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invitation_code": code}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listInvitationCodesHandler returns every invitation code, along with how
+// often each has been used.
+func (app *application) listInvitationCodesHandler(w http.ResponseWriter, r *http.Request) {
+	codes, err := app.models.InvitationCodes.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitation_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteInvitationCodeHandler revokes an invitation code.
+func (app *application) deleteInvitationCodeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.InvitationCodes.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invitation code successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountLockoutPolicy returns the lockout policy for failed logins to a single
+// account, and ipLockoutPolicy the one for failed logins from a single client.
+// The IP threshold is higher, as several users can share an address.
+func (app *application) accountLockoutPolicy() data.LockoutPolicy {
+	return data.LockoutPolicy{
+		Threshold: app.config.lockout.threshold,
+		BaseDelay: app.config.lockout.baseDelay,
+		MaxDelay:  app.config.lockout.maxDelay,
+		Window:    app.config.lockout.window,
+	}
+}
+
+func (app *application) ipLockoutPolicy() data.LockoutPolicy {
+	policy := app.accountLockoutPolicy()
+	policy.Threshold = app.config.lockout.ipThreshold
+	return policy
+}
+
+// clientIP returns the IP address that failed logins are counted against. It is
+// the address of the connection, unless that is one of the trusted proxies. Then
+// the X-Forwarded-For header is followed from the right, past any other trusted
+// proxies, or X-Real-IP is used if there is no X-Forwarded-For header. Headers
+// from anyone else are ignored, as a client could set them to get around its
+// own lockout or to lock out someone else.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+
+	addr = addr.Unmap()
+
+	if !app.trustedProxy(addr) {
+		return addr.String()
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		entries := strings.Split(strings.Join(forwarded, ","), ",")
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			next, err := netip.ParseAddr(strings.TrimSpace(entries[i]))
+			if err != nil {
+				break
+			}
+
+			addr = next.Unmap()
+
+			if !app.trustedProxy(addr) {
+				break
+			}
+		}
+
+		return addr.String()
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+
+	return addr.String()
+}
+
+func (app *application) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range app.config.lockout.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkLoginLockout sends a 429 response and returns false if logins for the
+// email address, or from the client's IP address, are locked. The response is
+// the same whether or not the address is registered.
+func (app *application) checkLoginLockout(w http.ResponseWriter, r *http.Request, email string) bool {
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(r.Context(),
+		data.LoginAttemptEmailKey(email), data.LoginAttemptIPKey(app.clientIP(r)))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if retryAfter := time.Until(lockedUntil); retryAfter > 0 {
+		app.loginLockedResponse(w, r, retryAfter)
+		return false
+	}
+
+	return true
+}
+
+// recordFailedLogin counts a failed login against both the email address and
+// the client's IP address. The user is nil if the address isn't registered;
+// otherwise they are emailed when the failure locks their account.
+func (app *application) recordFailedLogin(r *http.Request, email string, user *data.User) error {
+	_, err := app.models.LoginAttempts.RecordFailure(r.Context(),
+		data.LoginAttemptIPKey(app.clientIP(r)), app.ipLockoutPolicy())
+	if err != nil {
+		return err
+	}
+
+	policy := app.accountLockoutPolicy()
+
+	attempt, err := app.models.LoginAttempts.RecordFailure(r.Context(), data.LoginAttemptEmailKey(email), policy)
+	if err != nil {
+		return err
+	}
+
+	if user != nil && attempt.Failures == policy.Threshold && attempt.LockedUntil != nil {
+		lockedUntil := *attempt.LockedUntil
+
+		app.logger.PrintInfo("account locked after failed logins", map[string]string{
+			"user_id": strconv.FormatInt(user.ID, 10),
+			"ip":      app.clientIP(r),
+		})
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+			}
+
+			err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	return nil
+}
+
+// unlockUserHandler clears the failed logins and any lock on a user's account.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.LoginAttempts.Reset(r.Context(), data.LoginAttemptEmailKey(user.Email))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.lockout.trustedProxies = []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct", "203.0.113.5:1234", nil, "", "203.0.113.5"},
+		{"untrusted X-Forwarded-For", "203.0.113.5:1234", []string{"198.51.100.1"}, "", "203.0.113.5"},
+		{"untrusted X-Real-IP", "203.0.113.5:1234", nil, "198.51.100.1", "203.0.113.5"},
+		{"trusted proxy without headers", "10.0.0.1:1234", nil, "", "10.0.0.1"},
+		{"trusted X-Forwarded-For", "10.0.0.1:1234", []string{"203.0.113.5"}, "", "203.0.113.5"},
+		{"trusted X-Real-IP", "10.0.0.1:1234", nil, "203.0.113.5", "203.0.113.5"},
+		{"X-Forwarded-For before X-Real-IP", "10.0.0.1:1234", []string{"203.0.113.5"}, "198.51.100.1", "203.0.113.5"},
+		{"chain of trusted proxies", "10.0.0.1:1234", []string{"203.0.113.5, 10.0.0.2, 10.0.0.3"}, "", "203.0.113.5"},
+		{"entries added by the client", "10.0.0.1:1234", []string{"198.51.100.1, 192.0.2.7, 203.0.113.5"}, "", "203.0.113.5"},
+		{"several header lines", "10.0.0.1:1234", []string{"198.51.100.1", "203.0.113.5, 10.0.0.2"}, "", "203.0.113.5"},
+		{"only trusted proxies", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"malformed entry", "10.0.0.1:1234", []string{"203.0.113.5, not-an-ip"}, "", "10.0.0.1"},
+		{"IPv6 proxy", "[::1]:1234", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		{"IPv4-mapped address", "[::ffff:203.0.113.5]:1234", nil, "", "203.0.113.5"},
+		{"IPv4-mapped trusted proxy", "[::ffff:10.0.0.1]:1234", []string{"::ffff:203.0.113.5"}, "", "203.0.113.5"},
+		{"no port", "203.0.113.5", nil, "", "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil)
+			r.RemoteAddr = tt.remoteAddr
+
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := app.clientIP(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"greenlight/internal/data"
 	"greenlight/internal/jsonlog"
+	"greenlight/internal/jwt"
 	"greenlight/internal/mailer"
+	"greenlight/internal/passhash"
+	"greenlight/internal/passpolicy"
+	"greenlight/internal/totp"
+	"net/netip"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,6 +40,8 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout time.Duration
+		searchConfig string
 	}
 	limiter struct {
 		rps     float64
@@ -47,14 +58,61 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	sessions struct {
+		touchInterval time.Duration
+	}
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	auth struct {
+		mode         string
+		signingKeys  []string
+		signingKeyID string
+	}
+	mfa struct {
+		issuer        string
+		encryptionKey string
+	}
+	passwords struct {
+		hasher       string
+		minEntropy   float64
+		breachedList string
+	}
+	lockout struct {
+		threshold   int
+		ipThreshold int
+		baseDelay   time.Duration
+		maxDelay    time.Duration
+		window      time.Duration
+		// trustedProxies are the addresses of the reverse proxies whose
+		// X-Forwarded-For and X-Real-IP headers are believed.
+		trustedProxies []netip.Prefix
+	}
+	registration struct {
+		mode           string
+		allowedDomains []string
+		defaultRole    string
+	}
+	permissionCache struct {
+		size int
+		ttl  time.Duration
+	}
+	pagination struct {
+		cursorKey string
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config         config
+	logger         *jsonlog.Logger
+	models         data.Models
+	mailer         mailer.Mailer
+	signingKeys    *jwt.KeySet
+	totpCipher     *totp.Cipher
+	passwordPolicy *passpolicy.Policy
+	cursorSigner   *data.CursorSigner
+	wg             sync.WaitGroup
 }
 
 func main() {
@@ -70,6 +128,15 @@ func main() {
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
+	// Read the per-query timeout. Each query also stops as soon as the request that
+	// triggered it is cancelled, for example when the client disconnects.
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL per-query timeout")
+
+	// Read the text search configuration used to search movie titles. The
+	// movies_title_idx index has to be built with the same configuration, which
+	// is 'simple' unless migration 000019 was changed to rebuild it with another.
+	flag.StringVar(&cfg.db.searchConfig, "db-search-config", "simple", "PostgreSQL text search configuration for movie titles")
+
 	// Create command line flags to read the setting values into the config struct.
 	// Notice that we use true as the default for the 'enabled' setting?
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum reqeusts per second")
@@ -90,6 +157,92 @@ func main() {
 		return nil
 	})
 
+	// Read how often the last used time of a session is recorded.
+	flag.DurationVar(&cfg.sessions.touchInterval, "session-touch-interval", 5*time.Minute, "Minimum interval between session last-used updates")
+
+	// Read the lifetimes of the access and refresh tokens issued at login. In
+	// signed mode the access token lifetime is also how long logging out,
+	// revoking sessions, changing the password, deactivating the user or changing
+	// their permissions or organization roles can take to apply, so keep it short.
+	flag.DurationVar(&cfg.tokens.accessTTL, "token-access-ttl", 15*time.Minute, "Authentication (access) token lifetime")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Refresh token lifetime")
+
+	// Read the authentication mode. In "signed" mode the authentication tokens
+	// handed out are signed access tokens, which are verified without a database
+	// lookup: everything a request is authorized with, organization roles
+	// included, is carried by the token until it expires. Opaque tokens are
+	// accepted in either mode, and looked up on every request.
+	flag.StringVar(&cfg.auth.mode, "auth-mode", "opaque", "Authentication token mode (opaque|signed)")
+
+	// Read the keys used to sign and verify access tokens. The flag can be given
+	// more than once, which allows keys to be rotated.
+	flag.Func("token-signing-key", "Access token signing key as id:algorithm:base64-key (hs256|ed25519), may be repeated", func(s string) error {
+		cfg.auth.signingKeys = append(cfg.auth.signingKeys, s)
+		return nil
+	})
+	flag.StringVar(&cfg.auth.signingKeyID, "token-signing-key-id", "", "ID of the key used to sign new access tokens (defaults to the first key)")
+
+	// Read the settings for two-factor authentication. TOTP secrets are encrypted
+	// with the key before they are stored; without a key users can't enroll.
+	flag.StringVar(&cfg.mfa.issuer, "mfa-issuer", "Greenlight", "Issuer name shown in authenticator apps")
+	flag.StringVar(&cfg.mfa.encryptionKey, "mfa-encryption-key", "", "Base64-encoded 32-byte key used to encrypt TOTP secrets")
+
+	// Read the algorithm used to hash new passwords. Hashes made by the other
+	// algorithm are still accepted, and are replaced when their user logs in.
+	flag.StringVar(&cfg.passwords.hasher, "password-hasher", "argon2id", "Password hashing algorithm (argon2id|bcrypt)")
+
+	// Read the password policy applied whenever a user chooses a password. The
+	// breached password list is a file or directory in the Have I Been Pwned
+	// SHA-1 formats, so that it can be checked without network access.
+	flag.Float64Var(&cfg.passwords.minEntropy, "password-min-entropy", 40, "Minimum estimated password strength in bits")
+	flag.StringVar(&cfg.passwords.breachedList, "password-breached-list", "", "Path to a breached password list (SHA-1 hashes)")
+
+	// Read the login lockout settings. After the threshold of failed logins for an
+	// account (or from an IP address) further logins are refused for the base
+	// delay, which doubles with each further failure up to the maximum. Failures
+	// are forgotten after a quiet period of the given window.
+	flag.IntVar(&cfg.lockout.threshold, "lockout-threshold", 5, "Failed logins before an account is locked (0 disables)")
+	flag.IntVar(&cfg.lockout.ipThreshold, "lockout-ip-threshold", 50, "Failed logins before an IP address is locked (0 disables)")
+	flag.DurationVar(&cfg.lockout.baseDelay, "lockout-base-delay", time.Minute, "Initial lockout duration")
+	flag.DurationVar(&cfg.lockout.maxDelay, "lockout-max-delay", time.Hour, "Maximum lockout duration")
+	flag.DurationVar(&cfg.lockout.window, "lockout-window", 24*time.Hour, "Period without failures after which the failure count is reset")
+
+	// Read the reverse proxies trusted to report the client's IP address. The
+	// lockout keys on the address of the connection unless it comes from one of
+	// these, as anyone can set the forwarding headers.
+	flag.Func("lockout-trusted-proxies", "Trusted reverse proxy addresses or CIDR ranges (space separated)", func(s string) error {
+		for _, field := range strings.Fields(s) {
+			prefix, err := parseTrustedProxy(field)
+			if err != nil {
+				return err
+			}
+			cfg.lockout.trustedProxies = append(cfg.lockout.trustedProxies, prefix)
+		}
+		return nil
+	})
+
+	// Read who may register. In "invite-only" mode registering requires an
+	// invitation code created by an admin, and in "domain-allowlist" mode only
+	// addresses at the allowed domains may register.
+	flag.StringVar(&cfg.registration.mode, "registration-mode", "open", "Registration mode (open|invite-only|domain-allowlist)")
+	flag.Func("registration-allowed-domains", "Email domains allowed to register in domain-allowlist mode (space separated)", func(s string) error {
+		cfg.registration.allowedDomains = strings.Fields(strings.ToLower(s))
+		return nil
+	})
+
+	// Read the role assigned to newly registered users. It must exist when a
+	// user registers; an empty name leaves new users without any permissions.
+	flag.StringVar(&cfg.registration.defaultRole, "registration-default-role", "viewer", "Role assigned to newly registered users (empty for none)")
+
+	// Read the size and TTL of the in-process cache of user permissions. Setting
+	// either to zero disables the cache.
+	flag.IntVar(&cfg.permissionCache.size, "permission-cache-size", 10000, "Maximum number of users whose permissions are cached (0 disables)")
+	flag.DurationVar(&cfg.permissionCache.ttl, "permission-cache-ttl", time.Minute, "How long cached permissions are used for (0 disables)")
+
+	// Read the key used to sign pagination cursors. Without one a random key is
+	// used, and cursors stop working when the server restarts.
+	flag.StringVar(&cfg.pagination.cursorKey, "cursor-signing-key", "", "Base64-encoded key used to sign pagination cursors (at least 32 bytes)")
+
 	// Create a new version boolean flag with the default value of false.
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -103,6 +256,36 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	signingKeys, err := openSigningKeys(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	err = checkRegistrationMode(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	passhash.Default, err = passhash.New(cfg.passwords.hasher)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	passwordPolicy, err := openPasswordPolicy(cfg, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	totpCipher, err := openTOTPCipher(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	cursorSigner, err := openCursorSigner(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -133,11 +316,39 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	models := data.NewModels(db, cfg.db.queryTimeout, cfg.db.searchConfig)
+
+	if cfg.permissionCache.size > 0 && cfg.permissionCache.ttl > 0 {
+		cache := data.NewPermissionCache(cfg.permissionCache.size, cfg.permissionCache.ttl)
+
+		// Listen for permission changes made through other API instances, so
+		// that they don't have to wait for the entries to expire.
+		listener, err := cache.Listen(cfg.db.dsn, func(err error) {
+			logger.PrintError(err, nil)
+		})
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		defer listener.Close()
+
+		models = models.WithPermissionCache(cache)
+
+		// Publish the permission cache hit and miss counters.
+		expvar.Publish("permission_cache", expvar.Func(func() interface{} {
+			return cache.Stats()
+		}))
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer,
+		config:         cfg,
+		logger:         logger,
+		models:         models,
+		mailer:         mailer,
+		signingKeys:    signingKeys,
+		totpCipher:     totpCipher,
+		passwordPolicy: passwordPolicy,
+		cursorSigner:   cursorSigner,
 	}
 
 	err = app.serve()
@@ -147,6 +358,137 @@ func main() {
 	}
 }
 
+// openSigningKeys parses the configured access token signing keys. It returns a
+// nil KeySet if no keys are configured, which is only allowed in opaque mode.
+func openSigningKeys(cfg config) (*jwt.KeySet, error) {
+	switch cfg.auth.mode {
+	case "opaque", "signed":
+	default:
+		return nil, fmt.Errorf("invalid -auth-mode %q", cfg.auth.mode)
+	}
+
+	if len(cfg.auth.signingKeys) == 0 {
+		if cfg.auth.mode == "signed" {
+			return nil, errors.New("-auth-mode=signed requires at least one -token-signing-key")
+		}
+		return nil, nil
+	}
+
+	var keys []*jwt.Key
+
+	for _, s := range cfg.auth.signingKeys {
+		key, err := jwt.ParseKey(s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return jwt.NewKeySet(cfg.auth.signingKeyID, keys...)
+}
+
+// parseTrustedProxy parses an IP address or CIDR range.
+func parseTrustedProxy(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return prefix.Masked(), nil
+}
+
+// checkRegistrationMode checks that the registration mode is known, and that
+// domain-allowlist mode comes with at least one domain.
+func checkRegistrationMode(cfg config) error {
+	switch cfg.registration.mode {
+	case "open", "invite-only":
+	case "domain-allowlist":
+		if len(cfg.registration.allowedDomains) == 0 {
+			return errors.New("-registration-mode=domain-allowlist requires -registration-allowed-domains")
+		}
+	default:
+		return fmt.Errorf("invalid -registration-mode %q", cfg.registration.mode)
+	}
+
+	return nil
+}
+
+// openPasswordPolicy returns the configured password policy, loading the
+// breached password list if one is given.
+func openPasswordPolicy(cfg config, logger *jsonlog.Logger) (*passpolicy.Policy, error) {
+	policy := &passpolicy.Policy{MinEntropy: cfg.passwords.minEntropy}
+
+	if cfg.passwords.breachedList == "" {
+		return policy, nil
+	}
+
+	breached, err := passpolicy.LoadBreachedList(cfg.passwords.breachedList)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.PrintInfo("breached password list loaded", map[string]string{
+		"passwords": strconv.Itoa(breached.Len()),
+	})
+
+	policy.Breached = breached
+
+	return policy, nil
+}
+
+// openTOTPCipher returns the cipher used to encrypt TOTP secrets, or nil if no
+// -mfa-encryption-key is configured.
+func openTOTPCipher(cfg config) (*totp.Cipher, error) {
+	if cfg.mfa.encryptionKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.mfa.encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -mfa-encryption-key: %w", err)
+	}
+
+	if len(key) != 32 {
+		return nil, errors.New("invalid -mfa-encryption-key: must be 32 bytes long")
+	}
+
+	return totp.NewCipher(key)
+}
+
+// openCursorSigner returns the signer of pagination cursors, using a random key
+// if no -cursor-signing-key is configured.
+func openCursorSigner(cfg config) (*data.CursorSigner, error) {
+	if cfg.pagination.cursorKey == "" {
+		key := make([]byte, 32)
+
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return data.NewCursorSigner(key), nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.pagination.cursorKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -cursor-signing-key: %w", err)
+	}
+
+	if len(key) < 32 {
+		return nil, errors.New("invalid -cursor-signing-key: must be at least 32 bytes long")
+	}
+
+	return data.NewCursorSigner(key), nil
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -175,6 +517,13 @@ func openDB(cfg config) (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// Check that the text search configuration exists, rather than failing
+	// every search later on.
+	_, err = db.ExecContext(ctx, "SELECT $1::regconfig", cfg.db.searchConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -db-search-config: %w", err)
+	}
 	// Return the sql.DB connection pool.
 	return db, nil
 }
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/totp"
+	"greenlight/internal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mfaTokenTTL is how long the client has to complete a login with its second
+// factor once the password has been checked.
+const mfaTokenTTL = 5 * time.Minute
+
+// mfaEnabled reports whether the user has confirmed a TOTP enrollment, in which
+// case logging in takes a second factor as well as the password.
+func (app *application) mfaEnabled(ctx context.Context, userID int64) (bool, error) {
+	enrollment, err := app.models.MFA.GetTOTP(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return enrollment.Confirmed, nil
+}
+
+// verifySecondFactor checks a code given as the user's second factor. A code of
+// totp.Digits characters is checked as a TOTP code, and anything else as one of
+// the user's recovery codes. Either way the code is used up, so that it can't be
+// presented again.
+func (app *application) verifySecondFactor(ctx context.Context, userID int64, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	if len(code) != totp.Digits {
+		err := app.models.MFA.UseRecoveryCode(ctx, userID, code)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	enrollment, err := app.models.MFA.GetTOTP(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	if !enrollment.Confirmed {
+		return false, nil
+	}
+
+	step, ok, err := app.checkTOTPCode(enrollment, code)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	err = app.models.MFA.UseTOTPStep(ctx, userID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPCodeReused):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// checkTOTPCode decrypts the secret of a TOTP enrollment and checks the code
+// against it, returning the time step the code belongs to.
+func (app *application) checkTOTPCode(enrollment *data.TOTP, code string) (int64, bool, error) {
+	if app.totpCipher == nil {
+		return 0, false, errors.New("two-factor authentication is enabled for a user but no -mfa-encryption-key is configured")
+	}
+
+	secret, err := app.totpCipher.Decrypt(enrollment.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	return step, ok, nil
+}
+
+// createTOTPHandler starts a TOTP enrollment for the authenticated user, given
+// their current password. It returns the new secret, both as text and as a
+// provisioning URI for authenticator apps. Two-factor authentication is only
+// enabled once the enrollment is confirmed with a code generated from the
+// secret.
+func (app *application) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if app.totpCipher == nil {
+		app.mfaUnavailableResponse(w, r)
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.CurrentPassword != "", "current_password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only the encrypted secret is stored, so that a copy of the database alone
+	// isn't enough to generate codes.
+	ciphertext, err := app.totpCipher.Encrypt(secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.SetTOTP(r.Context(), user.ID, ciphertext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnabled):
+			app.mfaEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"totp": map[string]string{
+		"secret":           totp.EncodeSecret(secret),
+		"provisioning_uri": totp.ProvisioningURI(app.config.mfa.issuer, user.Email, secret),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler enables two-factor authentication for the authenticated
+// user, given a valid code for their pending enrollment. The response holds
+// the user's recovery codes, which are never shown again.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	enrollment, err := app.models.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "no two-factor enrollment is pending")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if enrollment.Confirmed {
+		app.mfaEnabledResponse(w, r)
+		return
+	}
+
+	step, ok, err := app.checkTOTPCode(enrollment, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.MFA.ConfirmTOTP(r.Context(), user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTOTPHandler disables two-factor authentication for the authenticated
+// user. Both the password and a current code (or a recovery code) are
+// required, so that a stolen session alone can't be used to turn it off.
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	enabled, err := app.mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !enabled {
+		app.mfaNotEnabledResponse(w, r)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r.Context(), user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.MFA.DeleteTOTP(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationTokenHandler completes a two-step login by exchanging
+// the mfa-pending token issued for the password, together with a TOTP code or
+// recovery code, for a new session. The mfa-pending token is used up before the
+// code is checked, so each password check only allows a single guess, however
+// many requests are sent with it at once. A wrong code counts as a failed login
+// towards the account lockout.
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.VaidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, err := app.models.Tokens.Consume(r.Context(), data.ScopeMFAPending, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r.Context(), user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		err = app.recordFailedLogin(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeMFAPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.LoginAttempts.Reset(r.Context(), data.LoginAttemptEmailKey(user.Email))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := app.newSession(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"authentication_token": token, "refresh_token": refreshToken}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCreateMFAAuthenticationTokenHandler(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+	ctx := context.Background()
+
+	user, _ := newTestUser(t, app, "alice@example.com")
+
+	err := app.models.MFA.SetTOTP(ctx, user.ID, []byte("sealed secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := app.models.MFA.ConfirmTOTP(ctx, user.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// login checks the password and returns the mfa-pending token handed out
+	// for it.
+	login := func(t *testing.T) string {
+		t.Helper()
+
+		res := send(t, h, http.MethodPost, "/v1/tokens/authentication", "", -1,
+			`{"email": "alice@example.com", "password": "pa55word1234"}`)
+		if res.status != http.StatusOK || res.body.MFAToken == nil {
+			t.Fatalf("login: got status %d %v; want %d and an mfa token", res.status, res.body.Error, http.StatusOK)
+		}
+
+		return res.body.MFAToken.Plaintext
+	}
+
+	exchange := func(token, code string) string {
+		return `{"token": "` + token + `", "code": "` + code + `"}`
+	}
+
+	t.Run("right code", func(t *testing.T) {
+		token := login(t)
+
+		res := send(t, h, http.MethodPost, "/v1/tokens/mfa", "", -1, exchange(token, codes[0]))
+		if res.status != http.StatusCreated || res.body.AuthenticationToken == nil {
+			t.Fatalf("got status %d %v; want %d and a token", res.status, res.body.Error, http.StatusCreated)
+		}
+
+		res = send(t, h, http.MethodPost, "/v1/tokens/mfa", "", -1, exchange(token, codes[1]))
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("reused token: got status %d; want %d", res.status, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		token := login(t)
+
+		res := send(t, h, http.MethodPost, "/v1/tokens/mfa", "", -1, exchange(token, "wrong-code"))
+		if res.status != http.StatusUnauthorized {
+			t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, http.StatusUnauthorized)
+		}
+
+		// The guess used up the token, so the right code can't follow it.
+		res = send(t, h, http.MethodPost, "/v1/tokens/mfa", "", -1, exchange(token, codes[2]))
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("after a wrong code: got status %d; want %d", res.status, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("concurrent guesses", func(t *testing.T) {
+		token := login(t)
+
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			statuses = map[int]int{}
+		)
+
+		for _, code := range codes[3:] {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				r := httptest.NewRequest(http.MethodPost, "/v1/tokens/mfa", strings.NewReader(exchange(token, code)))
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, r)
+
+				mu.Lock()
+				statuses[rr.Code]++
+				mu.Unlock()
+			}()
+		}
+
+		wg.Wait()
+
+		// Only one request gets the token; the others find it used up.
+		if statuses[http.StatusCreated] != 1 || statuses[http.StatusUnprocessableEntity] != len(codes[3:])-1 {
+			t.Errorf("got statuses %v; want one %d and the rest %d", statuses, http.StatusCreated, http.StatusUnprocessableEntity)
+		}
+	})
+}
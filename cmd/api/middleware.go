@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"errors"
 	"expvar"
 	"fmt"
@@ -131,6 +132,33 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 // If the token is not provided or invalid, it returns an appropriate error response.
 // The next handler is then called to continue processing the request.
 func (app *application) autheticate(next http.Handler) http.Handler {
+	// Declare a mutex and a map holding the time at which this instance last
+	// recorded each token as used, keyed by the token hash. Together with the
+	// interval check done by Tokens.Touch() this throttles the writes of the
+	// tokens.last_used_at column.
+	var (
+		mu      sync.Mutex
+		touched = make(map[[sha256.Size]byte]time.Time)
+	)
+
+	// Launch a background goroutine which removes entries that are old enough
+	// to be touched again once every minute.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			mu.Lock()
+
+			for hash, lastTouched := range touched {
+				if time.Since(lastTouched) > app.config.sessions.touchInterval {
+					delete(touched, hash)
+				}
+			}
+
+			mu.Unlock()
+		}
+	}()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
 		// caches that the response may vary based on the value of the Authorization
@@ -154,6 +182,62 @@ func (app *application) autheticate(next http.Handler) http.Handler {
 
 		token := headerParts[1]
 
+		// API keys are told apart from tokens by their prefix. The user is loaded
+		// along with the key, and the key is kept in the request context so that
+		// requirePermission can narrow the user's permissions to those of the key.
+		if strings.HasPrefix(token, data.APIKeyPrefix) {
+			v := validator.New()
+
+			if data.ValidateAPIKeyPlaintext(v, token); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			key, user, err := app.models.APIKeys.GetForPlaintext(r.Context(), token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Signed access tokens are made up of three dot-separated parts, which
+		// can't appear in an opaque token. They are verified with the configured
+		// keys alone, without a database round trip.
+		if strings.Count(token, ".") == 2 {
+			if app.signingKeys == nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			claims, err := app.signingKeys.Verify(token, time.Now())
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			user := &data.User{
+				ID:        claims.UserID,
+				Activated: claims.Activated,
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetClaims(r, claims)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		if data.VaidateTokenPlaintext(v, token); !v.Valid() {
@@ -161,7 +245,7 @@ func (app *application) autheticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -172,7 +256,28 @@ func (app *application) autheticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Record that the session has been used, unless this instance has already
+		// done so within the touch interval. A failure here shouldn't stop the
+		// request, so it is only logged.
+		hash := sha256.Sum256([]byte(token))
+
+		mu.Lock()
+		lastTouched, found := touched[hash]
+		touch := !found || time.Since(lastTouched) > app.config.sessions.touchInterval
+		if touch {
+			touched[hash] = time.Now()
+		}
+		mu.Unlock()
+
+		if touch {
+			err = app.models.Tokens.Touch(r.Context(), token, app.config.sessions.touchInterval)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		next.ServeHTTP(w, r)
 	})
@@ -214,22 +319,20 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 // requirePermission is a middleware function that checks if the user has the required permission code.
 // It takes a permission code and a http.HandlerFunc as parameters and returns a http.HandlerFunc.
-// The middleware function checks if the user has the permission code by retrieving all permissions for the user.
+// The middleware function checks if the user has the permission code by retrieving all permissions for the request.
 // If there is an error retrieving the permissions, it returns a server error response.
 // If the user does not have the required permission, it returns a not permitted response.
 // Otherwise, it calls the next http.HandlerFunc in the chain.
 // This middleware function also requires the user to be activated, as enforced by the app.requireActivatedUser middleware.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-
-		Permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.permissionsForRequest(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		if !Permissions.Include(code) {
+		if !permissions.Include(code) {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -239,6 +342,72 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// rejectAPIKey is a middleware function that refuses requests authenticated with an API key.
+// It protects the endpoints which manage credentials, so that an API key can't be used to
+// create a key with wider permissions than its own.
+func (app *application) rejectAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireOrganizationRole is a middleware function that resolves the catalog the request works on.
+// Requests to the catalog of an organization are only let through if the user is a member with at
+// least the given role, in which case the membership is stored in the request context. Requests to
+// the shared catalog are let through as they are. The membership of a signed access token is taken
+// from its claims, so that those requests don't need a database lookup either.
+func (app *application) requireOrganizationRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := app.activeOrganizationID(r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if orgID == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var membership *data.Membership
+
+		if claims := app.contextGetClaims(r); claims != nil {
+			memberRole, ok := claims.Roles[orgID]
+			if !ok {
+				app.notMemberResponse(w, r)
+				return
+			}
+
+			membership = &data.Membership{OrganizationID: orgID, UserID: claims.UserID, Role: memberRole}
+		} else {
+			membership, err = app.models.Organizations.GetMembership(r.Context(), orgID, app.contextGetUser(r).ID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.notMemberResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+		}
+
+		if !membership.HasRole(role) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		r = app.contextSetMembership(r, membership)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // enableCORS is a middleware function that enables Cross-Origin Resource Sharing (CORS) for the API.
 // It adds the necessary headers to the response to allow requests from trusted origins.
 // The trusted origins are defined in the application configuration.
@@ -260,7 +429,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					if r.Method == http.MethodOptions &&
 						r.Header.Get("Access-Control-Request-method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+organizationHeader)
 						w.WriteHeader(http.StatusOK)
 						return
 					}
@@ -272,13 +441,17 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) metrics(next http.Handler) http.Handler {
-	// Initialize the new expvar variables when the middleware chain is first built.
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponseSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_micro_seconds")
-	totalResonsesSentByStatus := expvar.NewMap("total_reponses_sent_by_status")
+// The expvar variables updated by the metrics middleware. They are published
+// once per process, so that the middleware chain can be built more than once,
+// as the handler tests do.
+var (
+	totalRequestsReceived           = expvar.NewInt("total_requests_received")
+	totalResponseSent               = expvar.NewInt("total_responses_sent")
+	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_micro_seconds")
+	totalResonsesSentByStatus       = expvar.NewMap("total_reponses_sent_by_status")
+)
 
+func (app *application) metrics(next http.Handler) http.Handler {
 	// The following code will be run for every request ...
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Use the Add() method to increment the number of requests received by 1.
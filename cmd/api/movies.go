@@ -6,6 +6,9 @@ import (
 	"greenlight/internal/data"
 	"greenlight/internal/validator"
 	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -23,11 +26,15 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:          input.Title,
+		Year:           input.Year,
+		Runtime:        input.Runtime,
+		Genres:         input.Genres,
+		CreatedBy:      &user.ID,
+		OrganizationID: app.catalogID(r),
 	}
 
 	// Validate every input
@@ -41,7 +48,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Insert operation to the db
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -68,7 +75,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), app.catalogID(r), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -85,6 +92,50 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// showMovieOrSuggestHandler serves both GET /v1/movies/:id and GET
+// /v1/movies/suggest, as httprouter doesn't allow registering the static path
+// next to the parameter.
+func (app *application) showMovieOrSuggestHandler(w http.ResponseWriter, r *http.Request) {
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == "suggest" {
+		app.suggestMoviesHandler(w, r)
+		return
+	}
+
+	app.showMovieHandler(w, r)
+}
+
+// suggestMoviesHandler returns type-ahead suggestions for a partially typed
+// title, ranked by similarity and popularity. Small typos are tolerated.
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	prefix := app.readString(qs, "prefix", "")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(prefix != "", "prefix", "must be provided")
+	v.Check(len(prefix) <= 100, "prefix", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 25, "limit", "must be a maximum of 25")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Suggest(r.Context(), app.catalogID(r), prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -93,7 +144,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Fetch the existing movie record from db
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), app.catalogID(r), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -104,6 +155,10 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !app.checkMovieOwnership(w, r, movie) {
+		return
+	}
+
 	// Decalre an input struct to hold the expected data from the client.
 	var input struct {
 		Title   *string       `json:"title"`
@@ -145,7 +200,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Intercept any ErrEditConflict error and call the editConflictResponse()
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -170,8 +225,23 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	movie, err := app.models.Movies.Get(r.Context(), app.catalogID(r), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkMovieOwnership(w, r, movie) {
+		return
+	}
+
 	// Delete the movie from database
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(r.Context(), movie.OrganizationID, movie.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -191,8 +261,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilter
 		data.Filters
 	}
 
@@ -206,26 +275,182 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// genres matches movies with all of the given genres, genres_any those
+	// with at least one of them and genres_exclude those with none of them.
+	input.GenresAny = app.readCSV(qs, "genres_any", []string{})
+	input.GenresExclude = app.readCSV(qs, "genres_exclude", []string{})
+
+	// The q parameter is a search query in web search syntax, for example
+	// `"star wars" -clone or trek`.
+	input.Search = app.readString(qs, "q", "")
+
+	// The owner filter takes a user ID, or "me" for the movies created by the
+	// user making the request.
+	switch owner := app.readString(qs, "owner", ""); owner {
+	case "":
+	case "me":
+		input.Owner = app.contextGetUser(r).ID
+	default:
+		input.Owner = int64(app.readInt(qs, "owner", 0, v))
+		v.Check(input.Owner > 0, "owner", "must be a user ID or \"me\"")
+	}
+
+	// The year and runtime bounds are inclusive, and can be given as
+	// year_min/year_max or year_gte/year_lte.
+	input.YearMin = int32(app.readBound(qs, "year_min", "year_gte", v))
+	input.YearMax = int32(app.readBound(qs, "year_max", "year_lte", v))
+	input.RuntimeMin = data.Runtime(app.readBound(qs, "runtime_min", "runtime_gte", v))
+	input.RuntimeMax = data.Runtime(app.readBound(qs, "runtime_max", "runtime_lte", v))
+
+	// created_at_min and created_at_max take timestamps or dates, both
+	// inclusive, so a date as the maximum includes the whole of that day.
+	// Creation times are stored to the second.
+	input.CreatedFrom, _ = app.readTime(qs, "created_at_min", v)
+
+	createdTo, dateOnly := app.readTime(qs, "created_at_max", v)
+	switch {
+	case createdTo.IsZero():
+	case dateOnly:
+		input.CreatedUntil = createdTo.AddDate(0, 0, 1)
+	default:
+		input.CreatedUntil = createdTo.Truncate(time.Second).Add(time.Second)
+	}
+
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
-	input.Filters.Sort = app.readString(qs, "sort", "id")
+	// A cursor from the metadata of a previous response picks the page instead
+	// of its number, and carries the sort it was created for. Search results
+	// are sorted by relevance unless asked otherwise.
+	defaultSort := "id"
+	if input.Search != "" {
+		defaultSort = "relevance"
+	}
+
+	if s := qs.Get("cursor"); s != "" {
+		// Cursors are bound to the filters and catalog of the listing they were
+		// returned for, as their position means nothing in any other listing.
+		cursor, err := app.cursorSigner.Parse(s)
+		switch {
+		case err != nil:
+			v.AddError("cursor", "must be a cursor returned by a previous request")
+		case cursor.Scope != input.MovieFilter.CursorScope(app.catalogID(r)):
+			v.AddError("cursor", "must be used with the same filters as the request which returned it")
+		default:
+			input.Filters.Cursor = cursor
+			defaultSort = cursor.Sort
+		}
+
+		v.Check(!qs.Has("page"), "page", "must not be given together with a cursor")
+	}
+
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
 	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime",
-		"-id", "-title", "-year", "-runtime"}
+		"-id", "-title", "-year", "-runtime", "relevance"}
+
+	if input.Filters.Sort == "relevance" {
+		v.Check(input.Search != "", "sort", "relevance requires a search query (q)")
+	}
+
+	// Counting every matching movie is expensive, so the total is only included
+	// on request when paging by cursor. Paging by number includes it by default,
+	// as it always has.
+	includeTotal := app.readBool(qs, "include_total", v)
+	input.Filters.IncludeTotal = input.Filters.Cursor == nil
+	if includeTotal != nil {
+		input.Filters.IncludeTotal = *includeTotal
+	}
+
+	// The facets count the matching movies per genre, decade and runtime
+	// bucket. They take queries of their own, so are only included on request,
+	// and are then read from the same snapshot as the movies.
+	includeFacets := app.readBool(qs, "facets", v)
+
+	data.ValidateMovieFilter(v, input.MovieFilter)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	var (
+		movies   []*data.Movie
+		metadata data.Metadata
+		facets   *data.MovieFacets
+		err      error
+	)
+
+	if includeFacets != nil && *includeFacets {
+		movies, metadata, facets, err = app.models.Movies.GetAllWithFacets(r.Context(), app.catalogID(r),
+			input.MovieFilter, input.Filters)
+	} else {
+		movies, metadata, err = app.models.Movies.GetAll(r.Context(), app.catalogID(r), input.MovieFilter, input.Filters)
+	}
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	// When a search finds nothing, for example because of a typo, fall back to
+	// the movies with the most similar titles, and suggest the closest one. The
+	// response is marked as fuzzy, and leaves out the totals and facets, which
+	// would only describe the search that found nothing.
+	text := input.Search
+	if text == "" {
+		text = input.Title
+	}
+
+	if len(movies) == 0 && text != "" && input.Filters.Cursor == nil && input.Filters.Page == 1 {
+		similar, err := app.models.Movies.GetSimilar(r.Context(), app.catalogID(r), text, input.MovieFilter,
+			input.Filters.PageSize)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(similar) > 0 {
+			env := envelope{"movies": similar, "metadata": data.Metadata{}, "fuzzy": true,
+				"did_you_mean": similar[0].Title}
+
+			err = app.writeJSON(w, http.StatusOK, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	metadata.SignCursors(app.cursorSigner, input.MovieFilter.CursorScope(app.catalogID(r)))
+
+	env := envelope{"movies": movies, "metadata": metadata}
+	if facets != nil {
+		env["facets"] = facets
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// checkMovieOwnership sends a 403 response and returns false if the user making
+// the request may not change or delete the movie. Admins of an organization may
+// change any movie in its catalog.
+func (app *application) checkMovieOwnership(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	if membership := app.contextGetMembership(r); membership != nil && membership.HasRole(data.OrganizationRoleAdmin) {
+		return true
+	}
+
+	permissions, err := app.permissionsForRequest(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !movie.CanModify(app.contextGetUser(r).ID, permissions) {
+		app.notOwnerResponse(w, r)
+		return false
+	}
+
+	return true
+}
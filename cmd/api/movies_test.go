@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"greenlight/internal/data"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// insertTestMovies inserts movies into the shared catalog, created by the user,
+// and returns them with their IDs filled in.
+func insertTestMovies(t *testing.T, app *application, user *data.User, movies ...*data.Movie) []*data.Movie {
+	t.Helper()
+
+	for _, movie := range movies {
+		movie.CreatedBy = &user.ID
+
+		err := app.models.Movies.Insert(context.Background(), movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return movies
+}
+
+func TestCreateMovieHandler(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "alice@example.com")
+
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"valid", token, `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusCreated},
+		{"missing title", token, `{"year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusUnprocessableEntity},
+		{"duplicate genres", token, `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["a", "a"]}`, http.StatusUnprocessableEntity},
+		{"badly formed runtime", token, `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`, http.StatusBadRequest},
+		{"unknown field", token, `{"title": "Moana", "rating": 5}`, http.StatusBadRequest},
+		{"anonymous", "", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, h, http.MethodPost, "/v1/movies", tt.token, -1, tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			want := "/v1/movies/" + strconv.FormatInt(res.body.Movie.ID, 10)
+			if got := res.header.Get("Location"); got != want {
+				t.Errorf("got Location %q; want %q", got, want)
+			}
+
+			if res.body.Movie.Version != 1 || res.body.Movie.CreatedBy == nil {
+				t.Errorf("got movie %+v; want version 1 with its creator", res.body.Movie)
+			}
+		})
+	}
+}
+
+func TestCreateMovieHandlerWithoutPermission(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	user, token := newTestUser(t, app, "alice@example.com")
+
+	err := app.models.Permissions.RemoveForUser(context.Background(), user.ID, "movies:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := send(t, h, http.MethodPost, "/v1/movies", token, -1,
+		`{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`)
+	if res.status != http.StatusForbidden {
+		t.Errorf("got status %d; want %d", res.status, http.StatusForbidden)
+	}
+}
+
+func TestShowMovieHandler(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	user, token := newTestUser(t, app, "alice@example.com")
+	insertTestMovies(t, app, user, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{"existing movie", "/v1/movies/1", http.StatusOK},
+		{"missing movie", "/v1/movies/2", http.StatusNotFound},
+		{"negative ID", "/v1/movies/-1", http.StatusNotFound},
+		{"non-numeric ID", "/v1/movies/foo", http.StatusNotFound},
+		{"suggestions", "/v1/movies/suggest?prefix=moa", http.StatusOK},
+		{"suggestions without prefix", "/v1/movies/suggest", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, h, http.MethodGet, tt.url, token, -1, "")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, tt.wantStatus)
+			}
+		})
+	}
+
+	res := send(t, h, http.MethodGet, "/v1/movies/suggest?prefix=moan", token, -1, "")
+	if len(res.body.Suggestions) != 1 || res.body.Suggestions[0].Title != "Moana" {
+		t.Errorf("got suggestions %v; want Moana", res.body.Suggestions)
+	}
+}
+
+func TestUpdateMovieHandler(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	alice, aliceToken := newTestUser(t, app, "alice@example.com")
+	_, bobToken := newTestUser(t, app, "bob@example.com")
+
+	insertTestMovies(t, app, alice, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+
+	tests := []struct {
+		name        string
+		token       string
+		body        string
+		wantStatus  int
+		wantTitle   string
+		wantVersion int32
+	}{
+		{"partial update", aliceToken, `{"title": "Moana 2"}`, http.StatusOK, "Moana 2", 2},
+		{"invalid year", aliceToken, `{"year": 1000}`, http.StatusUnprocessableEntity, "Moana 2", 2},
+		{"not the owner", bobToken, `{"title": "Stolen"}`, http.StatusForbidden, "Moana 2", 2},
+		{"empty update", aliceToken, `{}`, http.StatusOK, "Moana 2", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, h, http.MethodPatch, "/v1/movies/1", tt.token, -1, tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, tt.wantStatus)
+			}
+
+			movie, err := app.models.Movies.Get(context.Background(), 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if movie.Title != tt.wantTitle || movie.Version != tt.wantVersion {
+				t.Errorf("got %q version %d; want %q version %d", movie.Title, movie.Version, tt.wantTitle, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestDeleteMovieHandler(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	alice, aliceToken := newTestUser(t, app, "alice@example.com")
+	_, bobToken := newTestUser(t, app, "bob@example.com")
+
+	insertTestMovies(t, app, alice, &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"not the owner", bobToken, http.StatusForbidden},
+		{"owner", aliceToken, http.StatusOK},
+		{"already deleted", aliceToken, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, h, http.MethodDelete, "/v1/movies/1", tt.token, -1, "")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestListMovieHandler(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	user, token := newTestUser(t, app, "alice@example.com")
+
+	insertTestMovies(t, app, user,
+		&data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+		&data.Movie{Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action", "adventure"}},
+		&data.Movie{Title: "Deadpool", Year: 2016, Runtime: 108, Genres: []string{"action", "comedy"}},
+		&data.Movie{Title: "The Breakfast Club", Year: 1985, Runtime: 97, Genres: []string{"drama"}},
+	)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []int64
+	}{
+		{"all", "", http.StatusOK, []int64{1, 2, 3, 4}},
+		{"title", "title=black+panther", http.StatusOK, []int64{2}},
+		{"genres", "genres=action,adventure", http.StatusOK, []int64{2}},
+		{"genres_any", "genres_any=comedy,drama", http.StatusOK, []int64{3, 4}},
+		{"year range", "year_min=2016&year_max=2016", http.StatusOK, []int64{1, 3}},
+		{"sort", "sort=-year", http.StatusOK, []int64{2, 1, 3, 4}},
+		{"search", "q=moana+or+deadpool&sort=id", http.StatusOK, []int64{1, 3}},
+		{"page", "page=2&page_size=3", http.StatusOK, []int64{4}},
+		{"unsafe sort", "sort=version", http.StatusUnprocessableEntity, nil},
+		{"relevance without q", "sort=relevance", http.StatusUnprocessableEntity, nil},
+		{"page size too large", "page_size=101", http.StatusUnprocessableEntity, nil},
+		{"inverted year range", "year_min=2018&year_max=2016", http.StatusUnprocessableEntity, nil},
+		{"bad cursor", "cursor=abc", http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, h, http.MethodGet, "/v1/movies?"+tt.query, token, -1, "")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK && !slices.Equal(movieIDs(res.body.Movies), tt.want) {
+				t.Errorf("got movies %v; want %v", movieIDs(res.body.Movies), tt.want)
+			}
+		})
+	}
+
+	t.Run("fuzzy fallback", func(t *testing.T) {
+		res := send(t, h, http.MethodGet, "/v1/movies?q=deadpol", token, -1, "")
+		if res.status != http.StatusOK || !res.body.Fuzzy || res.body.DidYouMean != "Deadpool" {
+			t.Errorf("got %d fuzzy %t did_you_mean %q; want a fuzzy match for Deadpool", res.status,
+				res.body.Fuzzy, res.body.DidYouMean)
+		}
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		var got []int64
+
+		query := url.Values{"sort": {"title"}, "page_size": {"3"}}
+		for {
+			res := send(t, h, http.MethodGet, "/v1/movies?"+query.Encode(), token, -1, "")
+			if res.status != http.StatusOK {
+				t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, http.StatusOK)
+			}
+
+			got = append(got, movieIDs(res.body.Movies)...)
+
+			if res.body.Metadata.NextCursor == "" {
+				break
+			}
+			query = url.Values{"cursor": {res.body.Metadata.NextCursor}, "page_size": {"3"}}
+		}
+
+		if want := []int64{2, 3, 1, 4}; !slices.Equal(got, want) {
+			t.Errorf("got movies %v; want %v", got, want)
+		}
+	})
+
+	t.Run("cursor from another listing", func(t *testing.T) {
+		org := &data.Organization{Name: "A"}
+
+		err := app.models.Organizations.Insert(context.Background(), org, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := send(t, h, http.MethodGet, "/v1/movies?genres=action&page_size=1", token, -1, "")
+		if res.status != http.StatusOK || res.body.Metadata.NextCursor == "" {
+			t.Fatalf("got status %d and metadata %+v; want a next cursor", res.status, res.body.Metadata)
+		}
+
+		cursor := url.QueryEscape(res.body.Metadata.NextCursor)
+
+		for _, c := range []struct {
+			name  string
+			query string
+			orgID int64
+			want  int
+		}{
+			{"same listing", "genres=action&page_size=1&cursor=" + cursor, -1, http.StatusOK},
+			{"other filters", "genres=drama&page_size=1&cursor=" + cursor, -1, http.StatusUnprocessableEntity},
+			{"no filters", "page_size=1&cursor=" + cursor, -1, http.StatusUnprocessableEntity},
+			{"other catalog", "genres=action&page_size=1&cursor=" + cursor, org.ID, http.StatusUnprocessableEntity},
+		} {
+			res := send(t, h, http.MethodGet, "/v1/movies?"+c.query, token, c.orgID, "")
+			if res.status != c.want {
+				t.Errorf("%s: got status %d %v; want %d", c.name, res.status, res.body.Error, c.want)
+			}
+		}
+	})
+}
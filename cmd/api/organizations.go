@@ -0,0 +1,486 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// organizationHeader lets a client pick the catalog that a single request works
+// on, overriding the user's active organization. A value of 0 picks the shared
+// catalog.
+const organizationHeader = "X-Organization-ID"
+
+// organizationInvitationTTL is how long an invitation to join an organization
+// can be accepted for.
+const organizationInvitationTTL = 7 * 24 * time.Hour
+
+// activeOrganizationID returns the ID of the organization whose catalog the
+// request works on, or 0 for the shared catalog. It is taken from the
+// organization header if there is one, then from the signed access token, and
+// otherwise from the organization the user has switched to. Membership isn't
+// checked here; see requireOrganizationRole.
+func (app *application) activeOrganizationID(r *http.Request) (int64, error) {
+	if s := r.Header.Get(organizationHeader); s != "" {
+		orgID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || orgID < 0 {
+			return 0, fmt.Errorf("invalid %s header", organizationHeader)
+		}
+
+		return orgID, nil
+	}
+
+	if claims := app.contextGetClaims(r); claims != nil {
+		return claims.OrganizationID, nil
+	}
+
+	return app.models.Organizations.GetActive(r.Context(), app.contextGetUser(r).ID)
+}
+
+// catalogID returns the ID of the organization whose catalog the request works
+// on, as resolved by requireOrganizationRole, or 0 for the shared catalog.
+func (app *application) catalogID(r *http.Request) int64 {
+	if membership := app.contextGetMembership(r); membership != nil {
+		return membership.OrganizationID
+	}
+
+	return 0
+}
+
+// organizationMembership returns the authenticated user's membership in the
+// organization given by the "id" URL parameter. It sends an error response and
+// returns nil if the user isn't a member with at least the given role; to
+// outsiders the organization appears not to exist.
+func (app *application) organizationMembership(w http.ResponseWriter, r *http.Request, role string) *data.Membership {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	membership, err := app.models.Organizations.GetMembership(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	if !membership.HasRole(role) {
+		app.notPermittedResponse(w, r)
+		return nil
+	}
+
+	return membership
+}
+
+// readMemberParam reads the "user_id" URL parameter, which identifies a member
+// of an organization.
+func (app *application) readMemberParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("user_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid user_id parameter")
+	}
+
+	return id, nil
+}
+
+// createOrganizationHandler creates an organization, with the authenticated
+// user as its first admin.
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	org := &data.Organization{Name: input.Name}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Insert(r.Context(), org, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/organizations/%d", org.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": org}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showOrganizationHandler returns an organization and its members. Only members
+// can see it.
+func (app *application) showOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.organizationMembership(w, r, data.OrganizationRoleViewer)
+	if membership == nil {
+		return
+	}
+
+	org, err := app.models.Organizations.Get(r.Context(), membership.OrganizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	members, err := app.models.Organizations.GetMembers(r.Context(), org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": org, "members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOrganizationInvitationHandler emails an invitation to join the
+// organization to an address. Only admins of the organization can invite.
+func (app *application) createOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.organizationMembership(w, r, data.OrganizationRoleAdmin)
+	if membership == nil {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidateOrganizationRole(v, input.Role)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	org, err := app.models.Organizations.Get(r.Context(), membership.OrganizationID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	invitation, err := app.models.OrganizationInvitations.New(r.Context(), org.ID, input.Email, input.Role,
+		user.ID, organizationInvitationTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"invitationToken":  invitation.Plaintext,
+			"organizationName": org.Name,
+			"inviterName":      user.Name,
+			"role":             invitation.Role,
+		}
+
+		err := app.mailer.Send(invitation.Email, "organization_invitation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateOrganizationMemberHandler changes the role of a member. Only admins of
+// the organization can change roles.
+func (app *application) updateOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.organizationMembership(w, r, data.OrganizationRoleAdmin)
+	if membership == nil {
+		return
+	}
+
+	userID, err := app.readMemberParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	member, err := app.models.Organizations.GetMembership(r.Context(), membership.OrganizationID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganizationRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	member.Role = input.Role
+
+	err = app.models.Organizations.UpdateMember(r.Context(), member)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOrganizationMemberHandler removes a member from an organization. Admins
+// can remove anyone, and every member can remove themselves.
+func (app *application) deleteOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.organizationMembership(w, r, data.OrganizationRoleViewer)
+	if membership == nil {
+		return
+	}
+
+	userID, err := app.readMemberParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if userID != membership.UserID && !membership.HasRole(data.OrganizationRoleAdmin) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	member, err := app.models.Organizations.GetMembership(r.Context(), membership.OrganizationID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Organizations.RemoveMember(r.Context(), member.OrganizationID, member.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOrganizationMembershipsHandler returns the organizations the
+// authenticated user belongs to, and the one they are working in.
+func (app *application) listOrganizationMembershipsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	memberships, err := app.models.Organizations.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	orgID, err := app.models.Organizations.GetActive(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"organizations": memberships, "active_organization_id": orgID}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptOrganizationInvitationHandler makes the authenticated user a member of
+// an organization, given an invitation sent to their email address.
+func (app *application) acceptOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.VaidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	membership, err := app.models.OrganizationInvitations.Accept(r.Context(), input.TokenPlaintext, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"membership": membership}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateActiveOrganizationHandler switches the organization the authenticated
+// user works in, or back to the shared catalog when organization_id is 0. In
+// signed mode the organization is carried by the access token, so a new access
+// token for the current session is returned along with the change.
+func (app *application) updateActiveOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		OrganizationID *int64 `json:"organization_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.OrganizationID != nil, "organization_id", "must be provided")
+	v.Check(input.OrganizationID == nil || *input.OrganizationID >= 0, "organization_id", "must not be negative")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	orgID := *input.OrganizationID
+
+	if orgID != 0 {
+		_, err = app.models.Organizations.GetMembership(r.Context(), orgID, user.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("organization_id", "must be an organization you are a member of")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = app.models.Organizations.SetActive(r.Context(), user.ID, orgID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"active_organization_id": orgID}
+
+	if claims := app.contextGetClaims(r); claims != nil {
+		fullUser, err := app.currentUser(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := app.signAccessToken(r.Context(), fullUser, claims.SessionID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env["authentication_token"] = token
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"greenlight/internal/data"
+	"greenlight/internal/jwt"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestOrganizationCatalogIsolation(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	alice, aliceToken := newTestUser(t, app, "alice@example.com")
+	bob, bobToken := newTestUser(t, app, "bob@example.com")
+
+	orgA := &data.Organization{Name: "A"}
+	orgB := &data.Organization{Name: "B"}
+
+	for _, tt := range []struct {
+		org   *data.Organization
+		admin *data.User
+	}{{orgA, alice}, {orgB, bob}} {
+		err := app.models.Organizations.Insert(ctx, tt.org, tt.admin.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each catalog holds a movie with its own genre, and titles which share a
+	// prefix, so that any leak shows up in the listings, facets and
+	// suggestions.
+	movies := map[int64]*data.Movie{
+		orgA.ID: {Title: "Alpha A", Year: 2001, Runtime: 100, Genres: []string{"drama"}, CreatedBy: &alice.ID, OrganizationID: orgA.ID},
+		orgB.ID: {Title: "Alpha B", Year: 2002, Runtime: 100, Genres: []string{"comedy"}, CreatedBy: &bob.ID, OrganizationID: orgB.ID},
+		0:       {Title: "Alpha Shared", Year: 2003, Runtime: 100, Genres: []string{"horror"}, CreatedBy: &alice.ID},
+	}
+
+	for _, movie := range movies {
+		err := app.models.Movies.Insert(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := app.routes()
+
+	// catalogs lists the catalogs Alice can work on, by the organization header
+	// she sends, together with the movie that belongs to it.
+	catalogs := []struct {
+		name   string
+		header int64
+		movie  *data.Movie
+	}{
+		{"organization A", orgA.ID, movies[orgA.ID]},
+		{"shared catalog", 0, movies[0]},
+	}
+
+	for _, c := range catalogs {
+		t.Run(c.name, func(t *testing.T) {
+			res := send(t, h, http.MethodGet, "/v1/movies?facets=true", aliceToken, c.header, "")
+			if res.status != http.StatusOK || len(res.body.Movies) != 1 || res.body.Movies[0].ID != c.movie.ID {
+				t.Errorf("list: got %d %v; want only movie %d", res.status, res.body.Movies, c.movie.ID)
+			}
+
+			if res.body.Facets == nil || len(res.body.Facets.Genres) != 1 || res.body.Facets.Genres[0].Genre != c.movie.Genres[0] {
+				t.Errorf("facets: got %+v; want only genre %q", res.body.Facets, c.movie.Genres[0])
+			}
+
+			res = send(t, h, http.MethodGet, "/v1/movies/suggest?prefix=alpha", aliceToken, c.header, "")
+			if res.status != http.StatusOK || len(res.body.Suggestions) != 1 || res.body.Suggestions[0].ID != c.movie.ID {
+				t.Errorf("suggest: got %d %v; want only movie %d", res.status, res.body.Suggestions, c.movie.ID)
+			}
+
+			res = send(t, h, http.MethodGet, "/v1/movies/"+strconv.FormatInt(c.movie.ID, 10), aliceToken, c.header, "")
+			if res.status != http.StatusOK {
+				t.Errorf("show own movie: got %d; want %d", res.status, http.StatusOK)
+			}
+
+			// The movies of every other catalog, organization B's among them,
+			// can't be seen or changed from this one.
+			for orgID, other := range movies {
+				if orgID == c.header {
+					continue
+				}
+
+				url := "/v1/movies/" + strconv.FormatInt(other.ID, 10)
+
+				for _, req := range []struct{ method, body string }{
+					{http.MethodGet, ""},
+					{http.MethodPatch, `{"title": "Changed"}`},
+					{http.MethodDelete, ""},
+				} {
+					res = send(t, h, req.method, url, aliceToken, c.header, req.body)
+					if res.status != http.StatusNotFound {
+						t.Errorf("%s movie %d: got %d; want %d", req.method, other.ID, res.status, http.StatusNotFound)
+					}
+				}
+
+				stored, err := app.models.Movies.Get(ctx, orgID, other.ID)
+				if err != nil || stored.Title != other.Title || stored.Version != 1 {
+					t.Errorf("movie %d: got %+v, %v; want it unchanged", other.ID, stored, err)
+				}
+			}
+
+			// A new movie is added to the catalog it is created in, and can
+			// then be updated and deleted there.
+			res = send(t, h, http.MethodPost, "/v1/movies", aliceToken, c.header,
+				`{"title": "Beta", "year": 2010, "runtime": "90 mins", "genres": ["drama"]}`)
+			if res.status != http.StatusCreated || res.body.Movie.OrganizationID != c.header {
+				t.Fatalf("create: got %d %+v; want a movie in catalog %d", res.status, res.body.Movie, c.header)
+			}
+
+			url := "/v1/movies/" + strconv.FormatInt(res.body.Movie.ID, 10)
+
+			res = send(t, h, http.MethodPatch, url, aliceToken, c.header, `{"title": "Gamma"}`)
+			if res.status != http.StatusOK {
+				t.Errorf("update own movie: got %d; want %d", res.status, http.StatusOK)
+			}
+
+			res = send(t, h, http.MethodDelete, url, aliceToken, c.header, "")
+			if res.status != http.StatusOK {
+				t.Errorf("delete own movie: got %d; want %d", res.status, http.StatusOK)
+			}
+		})
+	}
+
+	t.Run("organization B without membership", func(t *testing.T) {
+		for _, url := range []string{"/v1/movies", "/v1/movies/" + strconv.FormatInt(movies[orgB.ID].ID, 10),
+			"/v1/movies/suggest?prefix=alpha"} {
+
+			res := send(t, h, http.MethodGet, url, aliceToken, orgB.ID, "")
+			if res.status != http.StatusForbidden || res.body.Movies != nil || res.body.Movie != nil ||
+				res.body.Suggestions != nil {
+				t.Errorf("GET %s: got %d %+v; want %d and no movies", url, res.status, res.body, http.StatusForbidden)
+			}
+		}
+
+		res := send(t, h, http.MethodPut, "/v1/users/me/organization", aliceToken, -1,
+			`{"organization_id": `+strconv.FormatInt(orgB.ID, 10)+`}`)
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("switch: got %d; want %d", res.status, http.StatusUnprocessableEntity)
+		}
+
+		active, err := app.models.Organizations.GetActive(ctx, alice.ID)
+		if err != nil || active != 0 {
+			t.Errorf("got active organization %d, %v; want 0", active, err)
+		}
+	})
+
+	t.Run("active organization", func(t *testing.T) {
+		for _, c := range []struct {
+			orgID int64
+			want  int64
+		}{
+			{orgA.ID, movies[orgA.ID].ID},
+			{0, movies[0].ID},
+		} {
+			res := send(t, h, http.MethodPut, "/v1/users/me/organization", aliceToken, -1,
+				`{"organization_id": `+strconv.FormatInt(c.orgID, 10)+`}`)
+			if res.status != http.StatusOK {
+				t.Fatalf("switch to %d: got %d; want %d", c.orgID, res.status, http.StatusOK)
+			}
+
+			res = send(t, h, http.MethodGet, "/v1/movies", aliceToken, -1, "")
+			if got := movieIDs(res.body.Movies); !slices.Equal(got, []int64{c.want}) {
+				t.Errorf("list in %d: got %v; want %v", c.orgID, got, []int64{c.want})
+			}
+		}
+
+		// Removing a member from the organization they are working in takes
+		// them back to the shared catalog. Alice joins as an admin first, so
+		// that Bob isn't the last one.
+		addTestMember(t, app, orgB.ID, alice, data.OrganizationRoleAdmin)
+
+		res := send(t, h, http.MethodPut, "/v1/users/me/organization", bobToken, -1,
+			`{"organization_id": `+strconv.FormatInt(orgB.ID, 10)+`}`)
+		if res.status != http.StatusOK {
+			t.Fatalf("switch bob: got %d; want %d", res.status, http.StatusOK)
+		}
+
+		err := app.models.Organizations.RemoveMember(ctx, orgB.ID, bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res = send(t, h, http.MethodGet, "/v1/movies", bobToken, -1, "")
+		if got := movieIDs(res.body.Movies); !slices.Equal(got, []int64{movies[0].ID}) {
+			t.Errorf("list after removal: got %v; want %v", got, []int64{movies[0].ID})
+		}
+	})
+}
+
+func TestOrganizationRoleFromSignedToken(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	key, err := jwt.ParseKey("test:hs256:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.signingKeys, err = jwt.NewKeySet("test", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := newTestUser(t, app, "alice@example.com")
+	bob, _ := newTestUser(t, app, "bob@example.com")
+
+	orgA := &data.Organization{Name: "A"}
+	orgB := &data.Organization{Name: "B"}
+
+	for _, tt := range []struct {
+		org   *data.Organization
+		admin *data.User
+	}{{orgA, alice}, {orgB, bob}} {
+		err := app.models.Organizations.Insert(ctx, tt.org, tt.admin.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := app.signAccessToken(ctx, alice, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := app.routes()
+
+	for _, tt := range []struct {
+		name       string
+		orgID      int64
+		wantStatus int
+	}{
+		{"member", orgA.ID, http.StatusOK},
+		{"not a member", orgB.ID, http.StatusForbidden},
+		{"shared catalog", 0, http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, h, http.MethodGet, "/v1/movies", token.Plaintext, tt.orgID, "")
+			if res.status != tt.wantStatus {
+				t.Errorf("got status %d %v; want %d", res.status, res.body.Error, tt.wantStatus)
+			}
+		})
+	}
+
+	// The roles are those of the time the token was signed, so a removal only
+	// applies to the access tokens signed after it. Bob joins as an admin first,
+	// so that Alice isn't the last one.
+	addTestMember(t, app, orgA.ID, bob, data.OrganizationRoleAdmin)
+
+	err = app.models.Organizations.RemoveMember(ctx, orgA.ID, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := send(t, h, http.MethodGet, "/v1/movies", token.Plaintext, orgA.ID, "")
+	if res.status != http.StatusOK {
+		t.Errorf("old token: got status %d; want %d", res.status, http.StatusOK)
+	}
+
+	token, err = app.signAccessToken(ctx, alice, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = send(t, h, http.MethodGet, "/v1/movies", token.Plaintext, orgA.ID, "")
+	if res.status != http.StatusForbidden {
+		t.Errorf("new token: got status %d; want %d", res.status, http.StatusForbidden)
+	}
+}
+
+func TestOrganizationLastAdmin(t *testing.T) {
+	app := newTestApplication(t)
+
+	alice, aliceToken := newTestUser(t, app, "alice@example.com")
+	bob, bobToken := newTestUser(t, app, "bob@example.com")
+
+	org := &data.Organization{Name: "A"}
+
+	err := app.models.Organizations.Insert(context.Background(), org, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addTestMember(t, app, org.ID, bob, data.OrganizationRoleViewer)
+
+	h := app.routes()
+
+	// member returns the URL of the user's membership.
+	member := func(user *data.User) string {
+		return "/v1/organizations/" + strconv.FormatInt(org.ID, 10) + "/members/" + strconv.FormatInt(user.ID, 10)
+	}
+
+	steps := []struct {
+		name       string
+		token      string
+		method     string
+		user       *data.User
+		body       string
+		wantStatus int
+	}{
+		{"last admin demotes themselves", aliceToken, http.MethodPatch, alice, `{"role": "editor"}`, http.StatusConflict},
+		{"last admin leaves", aliceToken, http.MethodDelete, alice, "", http.StatusConflict},
+		{"last admin stays an admin", aliceToken, http.MethodPatch, alice, `{"role": "admin"}`, http.StatusOK},
+		{"viewer promoted", aliceToken, http.MethodPatch, bob, `{"role": "admin"}`, http.StatusOK},
+		{"one of two admins demotes themselves", aliceToken, http.MethodPatch, alice, `{"role": "viewer"}`, http.StatusOK},
+		{"new last admin leaves", bobToken, http.MethodDelete, bob, "", http.StatusConflict},
+		{"viewer leaves", aliceToken, http.MethodDelete, alice, "", http.StatusOK},
+	}
+
+	for _, step := range steps {
+		res := send(t, h, step.method, member(step.user), step.token, -1, step.body)
+		if res.status != step.wantStatus {
+			t.Fatalf("%s: got status %d %v; want %d", step.name, res.status, res.body.Error, step.wantStatus)
+		}
+	}
+
+	members, err := app.models.Organizations.GetMembers(context.Background(), org.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(members) != 1 || members[0].UserID != bob.ID || members[0].Role != data.OrganizationRoleAdmin {
+		t.Errorf("got members %+v; want only Bob as an admin", members)
+	}
+}
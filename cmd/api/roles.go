@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaultRole returns the role given to newly registered users, or nil if none
+// is configured.
+func (app *application) defaultRole(ctx context.Context) (*data.Role, error) {
+	name := app.config.registration.defaultRole
+	if name == "" {
+		return nil, nil
+	}
+
+	role, err := app.models.Roles.GetByName(ctx, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, fmt.Errorf("the default role %q does not exist", name)
+		default:
+			return nil, err
+		}
+	}
+
+	return role, nil
+}
+
+// checkPermissionCodes adds a validation error under the "permissions" key if
+// any of the codes isn't a known permission.
+func (app *application) checkPermissionCodes(ctx context.Context, v *validator.Validator, codes []string) error {
+	known, err := app.models.Permissions.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		v.Check(known.Include(code), "permissions", fmt.Sprintf("must only contain known permissions (%q is not one of them)", code))
+	}
+
+	return nil
+}
+
+// listPermissionsHandler returns every permission code that can be granted.
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+	}
+
+	if role.Permissions == nil {
+		role.Permissions = data.Permissions{}
+	}
+
+	v := validator.New()
+
+	data.ValidateRole(v, role)
+
+	err = app.checkPermissionCodes(r.Context(), v, role.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Insert(r.Context(), role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/roles/%d", role.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := app.models.Roles.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateRoleHandler renames a role or replaces its permissions. The change
+// applies to every user the role is assigned to.
+func (app *application) updateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := app.models.Roles.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name        *string  `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil && *input.Name != role.Name {
+		if role.Name == app.config.registration.defaultRole {
+			app.defaultRoleResponse(w, r)
+			return
+		}
+
+		role.Name = *input.Name
+	}
+
+	if input.Permissions != nil {
+		role.Permissions = input.Permissions
+	}
+
+	v := validator.New()
+
+	data.ValidateRole(v, role)
+
+	err = app.checkPermissionCodes(r.Context(), v, role.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Update(r.Context(), role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteRoleHandler deletes a role, taking it away from every user it was
+// assigned to. The default role for new users can't be deleted.
+func (app *application) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := app.models.Roles.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if role.Name == app.config.registration.defaultRole {
+		app.defaultRoleResponse(w, r)
+		return
+	}
+
+	err = app.models.Roles.Delete(r.Context(), role.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addUserRoleHandler assigns a role, given by name, to a user.
+func (app *application) addUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Role != "", "role", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	role, err := app.models.Roles.GetByName(r.Context(), input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("role", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Roles.AddForUser(r.Context(), user.ID, role.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserAccess(w, r, user)
+}
+
+// deleteUserRoleHandler takes a role, given by name in the URL, away from a user.
+func (app *application) deleteUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	name := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	role, err := app.models.Roles.GetByName(r.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Roles.RemoveForUser(r.Context(), user.ID, role.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserAccess(w, r, user)
+}
+
+// addUserPermissionsHandler grants permissions to a user directly, on top of
+// those of their roles.
+func (app *application) addUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+
+	err = app.checkPermissionCodes(r.Context(), v, input.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(r.Context(), user.ID, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserAccess(w, r, user)
+}
+
+// deleteUserPermissionHandler revokes a permission, given by code in the URL,
+// that was granted to a user directly. A permission the user also holds
+// through one of their roles stays in effect until that role is removed.
+func (app *application) deleteUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	direct, err := app.models.Permissions.GetDirectForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !direct.Include(code) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Permissions.RemoveForUser(r.Context(), user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserAccess(w, r, user)
+}
+
+// writeUserAccess sends a user together with their roles, the permissions
+// granted to them directly, and the permissions they hold overall. Signed
+// access tokens which have already been issued keep the permissions they were
+// issued with until they expire.
+func (app *application) writeUserAccess(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roleNames := []string{}
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+
+	if direct == nil {
+		direct = data.Permissions{}
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	env := envelope{
+		"user":               user,
+		"roles":              roleNames,
+		"direct_permissions": direct,
+		"permissions":        permissions,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"expvar"
+	"greenlight/internal/data"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -16,20 +17,117 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	// User should be activated
 	router.HandlerFunc(http.MethodGet, "/v1/movies",
-		app.requirePermission("movies:read", app.listMovieHandler))
+		app.requirePermission("movies:read",
+			app.requireOrganizationRole(data.OrganizationRoleViewer, app.listMovieHandler)))
 	router.HandlerFunc(http.MethodPost, "/v1/movies",
-		app.requirePermission("movies:write", app.createMovieHandler))
+		app.requirePermission("movies:write",
+			app.requireOrganizationRole(data.OrganizationRoleEditor, app.createMovieHandler)))
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id",
-		app.requirePermission("movies:read", app.showMovieHandler))
+		app.requirePermission("movies:read",
+			app.requireOrganizationRole(data.OrganizationRoleViewer, app.showMovieOrSuggestHandler)))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id",
-		app.requirePermission("movies:write", app.updateMovieHandler))
+		app.requirePermission("movies:write",
+			app.requireOrganizationRole(data.OrganizationRoleEditor, app.updateMovieHandler)))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id",
-		app.requirePermission("movies:write", app.deleteMovieHandler))
+		app.requirePermission("movies:write",
+			app.requireOrganizationRole(data.OrganizationRoleEditor, app.deleteMovieHandler)))
+
+	// Organizations
+	router.HandlerFunc(http.MethodPost, "/v1/organizations",
+		app.requireActivatedUser(app.rejectAPIKey(app.createOrganizationHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/organizations/:id",
+		app.requireActivatedUser(app.rejectAPIKey(app.showOrganizationHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/organizations/:id/invitations",
+		app.requireActivatedUser(app.rejectAPIKey(app.createOrganizationInvitationHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/organizations/:id/members/:user_id",
+		app.requireActivatedUser(app.rejectAPIKey(app.updateOrganizationMemberHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/organizations/:id/members/:user_id",
+		app.requireActivatedUser(app.rejectAPIKey(app.deleteOrganizationMemberHandler)))
 
 	// Users
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.updateUserEmailHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me",
+		app.requireAuthenticatedUser(app.rejectAPIKey(app.showCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me",
+		app.requireAuthenticatedUser(app.rejectAPIKey(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password",
+		app.requireAuthenticatedUser(app.rejectAPIKey(app.changePasswordHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email",
+		app.requireAuthenticatedUser(app.rejectAPIKey(app.createEmailChangeHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions",
+		app.requireAuthenticatedUser(app.rejectAPIKey(app.listSessionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id",
+		app.requireAuthenticatedUser(app.rejectAPIKey(app.deleteSessionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys",
+		app.requireActivatedUser(app.rejectAPIKey(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys",
+		app.requireActivatedUser(app.rejectAPIKey(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id",
+		app.requireActivatedUser(app.rejectAPIKey(app.deleteAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/organizations",
+		app.requireActivatedUser(app.rejectAPIKey(app.listOrganizationMembershipsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/organizations",
+		app.requireActivatedUser(app.rejectAPIKey(app.acceptOrganizationInvitationHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/organization",
+		app.requireActivatedUser(app.rejectAPIKey(app.updateActiveOrganizationHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa/totp",
+		app.requireActivatedUser(app.rejectAPIKey(app.createTOTPHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/mfa/totp/confirm",
+		app.requireActivatedUser(app.rejectAPIKey(app.confirmTOTPHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/mfa/totp",
+		app.requireActivatedUser(app.rejectAPIKey(app.deleteTOTPHandler)))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication",
+		app.requireAuthenticatedUser(app.rejectAPIKey(app.deleteAuthenticationTokenHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all",
+		app.requireAuthenticatedUser(app.rejectAPIKey(app.deleteAllAuthenticationTokensHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	// Administration
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.listUsersHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.showUserHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.updateUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.deleteUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/sessions",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.deleteUserSessionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.unlockUserHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.addUserRoleHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.deleteUserRoleHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.addUserPermissionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.deleteUserPermissionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.listPermissionsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.listRolesHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.createRoleHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles/:id",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.showRoleHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/roles/:id",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.updateRoleHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.deleteRoleHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/invitation-codes",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.listInvitationCodesHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/invitation-codes",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.createInvitationCodeHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/invitation-codes/:id",
+		app.requirePermission("users:admin", app.rejectAPIKey(app.deleteInvitationCodeHandler)))
 
 	// Debug Metrics
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,12 +13,21 @@ import (
 )
 
 func (app *application) serve() error {
+	// Create a base context for every request. It is cancelled if the graceful
+	// shutdown below doesn't complete in time, which in turn cancels any database
+	// queries that are still running for in-flight requests.
+	baseCtx, cancelBaseCtx := context.WithCancel(context.Background())
+	defer cancelBaseCtx()
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
 	// Create a shutdownError channel. We will use this to receive any errors
@@ -58,6 +68,7 @@ func (app *application) serve() error {
 		// Exit the application with a 0 (success) status code.
 		err := srv.Shutdown(ctx)
 		if err != nil {
+			cancelBaseCtx()
 			shutdownError <- err
 		}
 
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/jwt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/tomasen/realip"
+)
+
+// newSession starts a session for the user and returns its authentication token
+// and refresh token. In signed mode the authentication token is a signed access
+// token, and only the refresh token is stored in the database.
+func (app *application) newSession(r *http.Request, user *data.User) (*data.Token, *data.Token, error) {
+	if app.config.auth.mode != "signed" {
+		return app.models.Tokens.NewSession(r.Context(), user.ID, app.config.tokens.accessTTL,
+			app.config.tokens.refreshTTL, realip.FromRequest(r), r.UserAgent())
+	}
+
+	_, refreshToken, err := app.models.Tokens.NewSession(r.Context(), user.ID, 0,
+		app.config.tokens.refreshTTL, realip.FromRequest(r), r.UserAgent())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, err := app.signAccessToken(r.Context(), user, refreshToken.Family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, refreshToken, nil
+}
+
+// rotateSession exchanges a refresh token for a new authentication token and
+// refresh token, following the same rules as newSession.
+func (app *application) rotateSession(r *http.Request, refreshTokenPlaintext string) (*data.Token, *data.Token, error) {
+	if app.config.auth.mode != "signed" {
+		return app.models.Tokens.Rotate(r.Context(), refreshTokenPlaintext, app.config.tokens.accessTTL,
+			app.config.tokens.refreshTTL, realip.FromRequest(r), r.UserAgent())
+	}
+
+	_, refreshToken, err := app.models.Tokens.Rotate(r.Context(), refreshTokenPlaintext, 0,
+		app.config.tokens.refreshTTL, realip.FromRequest(r), r.UserAgent())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Load the user again, so that the new access token reflects any change to
+	// their activation status or permissions since the last one was signed.
+	user, err := app.models.Users.Get(r.Context(), refreshToken.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, err := app.signAccessToken(r.Context(), user, refreshToken.Family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, refreshToken, nil
+}
+
+// signAccessToken returns a signed access token for the user, carrying their
+// activation status, current permissions, active organization and
+// organization roles, and the ID of the session it belongs to.
+func (app *application) signAccessToken(ctx context.Context, user *data.User, sessionID string) (*data.Token, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	orgID, err := app.models.Organizations.GetActive(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberships, err := app.models.Organizations.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[int64]string, len(memberships))
+	for _, membership := range memberships {
+		roles[membership.OrganizationID] = membership.Role
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.tokens.accessTTL)
+
+	claims := jwt.Claims{
+		UserID:         user.ID,
+		Activated:      user.Activated,
+		Permissions:    permissions,
+		SessionID:      sessionID,
+		OrganizationID: orgID,
+		Roles:          roles,
+		IssuedAt:       now.Unix(),
+		Expiry:         expiry.Unix(),
+	}
+
+	plaintext, err := app.signingKeys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		Expiry:    time.Unix(claims.Expiry, 0),
+		Scope:     data.ScopeAuthentication,
+		Family:    sessionID,
+	}, nil
+}
+
+// currentSessionID returns the ID of the session that the request was made with.
+func (app *application) currentSessionID(r *http.Request) (string, error) {
+	if claims := app.contextGetClaims(r); claims != nil {
+		return claims.SessionID, nil
+	}
+
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(r.Context(), user.ID, app.contextGetToken(r))
+	if err != nil {
+		return "", err
+	}
+
+	for _, session := range sessions {
+		if session.Current {
+			return session.ID, nil
+		}
+	}
+
+	return "", nil
+}
+
+// listSessionsHandler returns the active sessions of the authenticated user, with
+// the client details recorded for each one.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(r.Context(), user.ID, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A signed access token isn't stored, so the current session is found from
+	// the session ID it carries instead.
+	if claims := app.contextGetClaims(r); claims != nil {
+		for _, session := range sessions {
+			session.Current = session.ID == claims.SessionID
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler revokes one of the authenticated user's sessions by its ID.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	err := app.models.Tokens.DeleteSession(r.Context(), user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
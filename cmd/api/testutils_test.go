@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/passpolicy"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestApplication returns an application backed by empty in-memory models,
+// configured like the defaults of the command-line flags.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	models, err := data.NewMemoryModels("simple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.sessions.touchInterval = 5 * time.Minute
+	cfg.tokens.accessTTL = 15 * time.Minute
+	cfg.tokens.refreshTTL = 30 * 24 * time.Hour
+	cfg.auth.mode = "opaque"
+	cfg.lockout.threshold = 5
+	cfg.lockout.ipThreshold = 50
+	cfg.lockout.baseDelay = time.Minute
+	cfg.lockout.maxDelay = time.Hour
+	cfg.lockout.window = 24 * time.Hour
+	cfg.registration.mode = "open"
+	cfg.registration.defaultRole = "viewer"
+
+	app := &application{
+		config:         cfg,
+		logger:         jsonlog.New(io.Discard, jsonlog.LevelFatal),
+		models:         models,
+		cursorSigner:   data.NewCursorSigner([]byte("0123456789abcdef0123456789abcdef")),
+		passwordPolicy: &passpolicy.Policy{MinEntropy: 40},
+	}
+
+	// Wait for the emails sent in the background, which fail without an SMTP
+	// server, so that they don't outlive the test.
+	t.Cleanup(app.wg.Wait)
+
+	return app
+}
+
+// newTestUser inserts an activated user who may read and write movies, and
+// returns them together with an authentication token for a new session.
+func newTestUser(t *testing.T, app *application, email string) (*data.User, string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	user := &data.User{Name: email, Email: email, Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Permissions.AddForUser(ctx, user.ID, "movies:read", "movies:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := app.models.Tokens.NewSession(ctx, user.ID, time.Hour, time.Hour, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, token.Plaintext
+}
+
+// testResponse is the status code, headers and decoded JSON body of a
+// response.
+type testResponse struct {
+	status int
+	header http.Header
+	body   struct {
+		Error       any                     `json:"error"`
+		Movie       *data.Movie             `json:"movie"`
+		Movies      []*data.Movie           `json:"movies"`
+		Metadata    *data.Metadata          `json:"metadata"`
+		Facets      *data.MovieFacets       `json:"facets"`
+		Fuzzy       bool                    `json:"fuzzy"`
+		DidYouMean  string                  `json:"did_you_mean"`
+		Suggestions []*data.MovieSuggestion `json:"suggestions"`
+		User        *data.User              `json:"user"`
+
+		AuthenticationToken *data.Token `json:"authentication_token"`
+		MFAToken            *data.Token `json:"mfa_token"`
+	}
+}
+
+// send makes a request to the handler as the holder of the token, or as an
+// anonymous user if the token is empty. An orgID of -1 leaves out the
+// organization header, so that the active organization is used.
+func send(t *testing.T, h http.Handler, method, url, token string, orgID int64, body string) testResponse {
+	t.Helper()
+
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if orgID >= 0 {
+		r.Header.Set(organizationHeader, strconv.FormatInt(orgID, 10))
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	res := testResponse{status: rr.Code, header: rr.Header()}
+
+	err := json.NewDecoder(rr.Body).Decode(&res.body)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+
+	return res
+}
+
+func movieIDs(movies []*data.Movie) []int64 {
+	ids := []int64{}
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+	}
+	return ids
+}
+
+// addTestMember makes the user a member of the organization with the role, by
+// inviting them and accepting the invitation.
+func addTestMember(t *testing.T, app *application, orgID int64, user *data.User, role string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	invitation, err := app.models.OrganizationInvitations.New(ctx, orgID, user.Email, role, user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = app.models.OrganizationInvitations.Accept(ctx, invitation.Plaintext, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"greenlight/internal/validator"
 	"net/http"
 	"time"
+
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
@@ -31,11 +33,22 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Refuse to check the password at all while logins for the email address or
+	// from the client's IP address are locked.
+	if !app.checkLoginLockout(w, r, input.Email) {
+		return
+	}
+
 	// check to see a user with the given email
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.recordFailedLogin(r, input.Email, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -51,20 +64,309 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	// if the passwords doesnt match, then we call the app.invalidCredentialsResponse()
 	if !match {
+		err = app.recordFailedLogin(r, input.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// Now that the plaintext password is known, upgrade a hash made with an older
+	// algorithm or weaker parameters. A failure here shouldn't stop the user from
+	// logging in, as the old hash still works.
+	if user.Password.NeedsRehash() {
+		err = app.rehashPassword(r, user, input.Password)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	// If the user has enabled two-factor authentication the password isn't
+	// enough. Hand out a short-lived mfa-pending token instead, which the client
+	// exchanges for a session together with a code from the second factor.
+	mfaEnabled, err := app.mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if mfaEnabled {
+		mfaToken, err := app.models.Tokens.New(r.Context(), user.ID, mfaTokenTTL, data.ScopeMFAPending)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"mfa_token": mfaToken}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The failed login count for the account is only reset once the login is
+	// complete, so that with two-factor authentication enabled a known password
+	// can't be used to make unlimited guesses at the second factor.
+	err = app.models.LoginAttempts.Reset(r.Context(), data.LoginAttemptEmailKey(input.Email))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Start a new session, made up of a short-lived authentication token and a
+	// refresh token which can be exchanged for new tokens when it expires.
+	token, refreshToken, err := app.newSession(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"authentication_token": token, "refresh_token": refreshToken}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+}
+
+// createPasswordResetTokenHandler emails a short-lived password reset token to the
+// user with the given email address. The response is the same whether or not such
+// a user exists, so that it can't be used to find out which addresses are
+// registered.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if a matching account exists, an email will be sent to it containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler replaces the activation token of an unactivated user
+// and emails the new one to them. As with password resets, the response doesn't
+// reveal whether the email address is registered or already activated.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if a matching unactivated account exists, an email will be sent to it containing activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && !user.Activated {
+		// Delete any outstanding activation tokens first, so that only the most
+		// recently emailed token can be used.
+		err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler revokes the authentication token used to make
+// the request, along with the refresh token of the same session, logging the client
+// out. Any further request with the same token is rejected by the autheticate
+// middleware. A signed access token stays valid until it expires, but its session
+// can no longer be refreshed.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// A signed access token can't be deleted, as it isn't stored. Instead the
+	// session it belongs to is revoked, so that it can no longer be refreshed.
+	if claims := app.contextGetClaims(r); claims != nil {
+		err = app.models.Tokens.DeleteSession(r.Context(), claims.UserID, claims.SessionID)
+	} else {
+		err = app.models.Tokens.DeleteFamily(r.Context(), data.ScopeAuthentication, app.contextGetToken(r))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler revokes every authentication and refresh
+// token belonging to the user, including the ones used by the current session.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new
+// authentication token and a new refresh token. Each refresh token can only be used
+// once; presenting a used one again revokes the whole session, as it means that
+// either the client or an attacker holds a stolen copy.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	v := validator.New()
 
+	if data.VaidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, refreshToken, err := app.rotateSession(r, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.logger.PrintInfo("refresh token reused, session revoked", map[string]string{
+				"ip": realip.FromRequest(r),
+			})
+			v.AddError("token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"authentication_token": token, "refresh_token": refreshToken}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
+
+// rehashPassword hashes the user's password again with the default password
+// hasher and saves it. An edit conflict means the user record changed in the
+// meantime, possibly with a new password, so the rehash is simply skipped.
+func (app *application) rehashPassword(r *http.Request, user *data.User, plaintextPassword string) error {
+	err := user.Password.Set(plaintextPassword)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		return err
+	}
 
+	return nil
 }
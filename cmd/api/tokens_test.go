@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"greenlight/internal/passhash"
+	"net/http"
+	"testing"
+)
+
+func TestCreateAuthenticationTokenHandler(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	newTestUser(t, app, "alice@example.com")
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"valid credentials", `{"email": "alice@example.com", "password": "pa55word1234"}`, http.StatusCreated},
+		{"wrong password", `{"email": "alice@example.com", "password": "wrongpa55word"}`, http.StatusUnauthorized},
+		{"unknown email", `{"email": "bob@example.com", "password": "pa55word1234"}`, http.StatusUnauthorized},
+		{"invalid email", `{"email": "alice", "password": "pa55word1234"}`, http.StatusUnprocessableEntity},
+		{"missing password", `{"email": "alice@example.com"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(t, h, http.MethodPost, "/v1/tokens/authentication", "", -1, tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			if res.body.AuthenticationToken == nil || res.body.AuthenticationToken.Plaintext == "" {
+				t.Fatalf("got token %+v; want a plaintext token", res.body.AuthenticationToken)
+			}
+
+			res = send(t, h, http.MethodGet, "/v1/movies", res.body.AuthenticationToken.Plaintext, -1, "")
+			if res.status != http.StatusOK {
+				t.Errorf("list with the new token: got %d; want %d", res.status, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestCreateAuthenticationTokenHandlerLockout(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	newTestUser(t, app, "alice@example.com")
+
+	for i := 0; i < app.config.lockout.threshold; i++ {
+		res := send(t, h, http.MethodPost, "/v1/tokens/authentication", "", -1,
+			`{"email": "alice@example.com", "password": "wrongpa55word"}`)
+		if res.status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got status %d; want %d", i+1, res.status, http.StatusUnauthorized)
+		}
+	}
+
+	// Once locked, even the right password is refused until the delay is over.
+	res := send(t, h, http.MethodPost, "/v1/tokens/authentication", "", -1,
+		`{"email": "alice@example.com", "password": "pa55word1234"}`)
+	if res.status != http.StatusTooManyRequests {
+		t.Errorf("got status %d %v; want %d", res.status, res.body.Error, http.StatusTooManyRequests)
+	}
+	if res.header.Get("Retry-After") == "" {
+		t.Error("got no Retry-After header")
+	}
+}
+
+func TestCreateAuthenticationTokenHandlerRehash(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	// Store a legacy bcrypt hash, as users registered before Argon2id have.
+	hasher := passhash.Default
+	passhash.Default = passhash.Bcrypt{Cost: 4}
+	user, _ := newTestUser(t, app, "alice@example.com")
+	passhash.Default = hasher
+
+	if !user.Password.NeedsRehash() {
+		t.Fatal("got a hash which doesn't need rehashing; want a legacy one")
+	}
+
+	res := send(t, h, http.MethodPost, "/v1/tokens/authentication", "", -1,
+		`{"email": "alice@example.com", "password": "pa55word1234"}`)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d %v; want %d", res.status, res.body.Error, http.StatusCreated)
+	}
+
+	stored, err := app.models.Users.GetByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Password.NeedsRehash() {
+		t.Error("got the legacy hash after login; want it upgraded")
+	}
+
+	match, err := stored.Password.Matches("pa55word1234")
+	if err != nil || !match {
+		t.Errorf("got match %t, %v for the upgraded hash; want true", match, err)
+	}
+}
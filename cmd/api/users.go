@@ -27,7 +27,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -41,7 +41,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -52,7 +52,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -76,9 +76,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// Create an anonymous struct to hold the expected data from the request body.
 
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name           string `json:"name"`
+		Email          string `json:"email"`
+		Password       string `json:"password"`
+		InvitationCode string `json:"invitation_code"`
 	}
 
 	// Parse the request body into the anonymous struct.
@@ -103,17 +104,49 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 
-	// Validate the user struct and return the error messages to the client
-	// if any of the checks fail.
-	if data.ValidateUser(v, user); !v.Valid() {
+	// Validate the user struct and the password policy and return the error
+	// messages to the client if any of the checks fail.
+	data.ValidateUser(v, user)
+	app.passwordPolicy.Validate(v, input.Password, user.Name, user.Email)
+
+	// Apply the registration mode. An invitation code is accepted in any mode,
+	// but only required in invite-only mode.
+	if app.config.registration.mode == "domain-allowlist" {
+		v.Check(app.emailDomainAllowed(user.Email), "email", "must be an address at a domain which is allowed to register")
+	}
+
+	if input.InvitationCode != "" || app.config.registration.mode == "invite-only" {
+		data.ValidateInvitationCodePlaintext(v, input.InvitationCode)
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	// Look up the role given to new users before creating the user, so that a
+	// missing role doesn't leave behind a user without any permissions.
+	role, err := app.defaultRole(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var roleID int64
+	if role != nil {
+		roleID = role.ID
+	}
+
+	// Insert the user data into the database, along with their role and the use
+	// and permissions of their invitation code. This happens in a single
+	// transaction, so that a failure doesn't leave behind a user with only some
+	// of their permissions, or an invitation code used up by nobody.
+	err = app.models.Users.Register(r.Context(), user, roleID, input.InvitationCode)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrInvalidInvitationCode):
+			v.AddError("invitation_code", "invalid, expired or used up invitation code")
+			app.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
@@ -123,15 +156,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	// After the user recored has been created in the databse, generate a new activation
 	// token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -145,7 +172,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 		// Call the Send() method on our Mailer, passing in the user's email address,
 		// name of the template file, and the User struct containing the new user's data
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
 		if err != nil {
 			app.logger.PrintError(err, nil)
 		}
@@ -157,3 +184,90 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 }
+
+// updateUserPasswordHandler sets a new password for the user holding a valid
+// password reset token. Once the password has been changed, all of the user's
+// password reset, authentication and refresh tokens are deleted, which logs out
+// every existing session, along with any half-finished second-factor login or
+// email change started by whoever knew the old password.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.VaidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if app.passwordPolicy.Validate(v, input.Password, user.Name, user.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Update() bumps the user's version, so any request still holding the old
+	// user record will get an edit conflict.
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	scopes := []string{
+		data.ScopePasswordReset,
+		data.ScopeAuthentication,
+		data.ScopeRefresh,
+		data.ScopeMFAPending,
+		data.ScopeEmailChange,
+	}
+
+	for _, scope := range scopes {
+		err = app.models.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
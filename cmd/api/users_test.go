@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/data"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateUserPasswordHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	alice, aliceToken := newTestUser(t, app, "alice@example.com")
+
+	reset, err := app.models.Tokens.New(ctx, alice.ID, time.Hour, data.ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mfaPending, err := app.models.Tokens.New(ctx, alice.ID, time.Hour, data.ScopeMFAPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emailChange, err := app.models.EmailChanges.New(ctx, alice.ID, "alice@example.org", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := app.routes()
+
+	res := send(t, h, http.MethodPut, "/v1/users/password", "", -1,
+		`{"password": "`+alice.Email+`", "token": "`+reset.Plaintext+`"}`)
+	if res.status != http.StatusUnprocessableEntity {
+		t.Fatalf("password with personal details: got %d; want %d", res.status, http.StatusUnprocessableEntity)
+	}
+
+	res = send(t, h, http.MethodPut, "/v1/users/password", "", -1,
+		`{"password": "correct horse battery staple", "token": "`+reset.Plaintext+`"}`)
+	if res.status != http.StatusOK {
+		t.Fatalf("reset: got %d %v; want %d", res.status, res.body.Error, http.StatusOK)
+	}
+
+	// Every token held by whoever knew the old password is gone.
+	for _, tt := range []struct {
+		scope string
+		token *data.Token
+	}{
+		{data.ScopePasswordReset, reset},
+		{data.ScopeMFAPending, mfaPending},
+	} {
+		_, err := app.models.Users.GetForToken(ctx, tt.scope, tt.token.Plaintext)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("%s token: got error %v; want %v", tt.scope, err, data.ErrRecordNotFound)
+		}
+	}
+
+	_, _, err = app.models.EmailChanges.GetForToken(ctx, emailChange.Plaintext)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("email-change token: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+
+	res = send(t, h, http.MethodGet, "/v1/movies", aliceToken, -1, "")
+	if res.status != http.StatusUnauthorized {
+		t.Errorf("old session: got %d; want %d", res.status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,244 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"greenlight/internal/validator"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key, which lets the authentication middleware
+// tell API keys apart from bearer tokens.
+const APIKeyPrefix = "glk_"
+
+// APIKey is a long-lived credential for machine clients. It is only granted the
+// permissions listed on the key, out of those its owner holds.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// generateAPIKey creates an API key in the same way as generateToken creates a
+// token: only the SHA-256 hash of the plaintext key is ever stored.
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// Check that the plaintext API key has the prefix and is exactly 36 bytes long.
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(strings.HasPrefix(keyPlaintext, APIKeyPrefix), "key", "must be a valid API key")
+	v.Check(len(keyPlaintext) == len(APIKeyPrefix)+32, "key", "must be 36 bytes long")
+}
+
+// APIKeyRepository is the set of operations the application performs on API keys.
+type APIKeyRepository interface {
+	New(ctx context.Context, userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error)
+	Insert(ctx context.Context, key *APIKey) error
+	GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	GetForPlaintext(ctx context.Context, keyPlaintext string) (*APIKey, *User, error)
+	Delete(ctx context.Context, userID, id int64) error
+}
+
+type APIKeyModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+func (m APIKeyModel) New(ctx context.Context, userID int64, name string, permissions Permissions,
+	expiry *time.Time) (*APIKey, error) {
+
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, key)
+	return key, err
+}
+
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []interface{}{key.UserID, key.Name, key.Hash, pq.Array(key.Permissions), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetAllForUser returns the API keys of a user, including expired ones, oldest
+// first. The plaintext keys are never returned.
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, permissions, expiry, created_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			pq.Array(&key.Permissions),
+			&key.Expiry,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetForPlaintext returns an unexpired API key together with the user it
+// belongs to.
+func (m APIKeyModel) GetForPlaintext(ctx context.Context, keyPlaintext string) (*APIKey, *User, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		SELECT api_keys.id, api_keys.name, api_keys.permissions, api_keys.expiry,
+		api_keys.created_at, users.id, users.created_at, users.name, users.email,
+		users.password_hash, users.activated, users.version
+		FROM api_keys
+		INNER JOIN users
+		ON users.id = api_keys.user_id
+		WHERE api_keys.hash = $1
+		AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)`
+
+	var (
+		key  APIKey
+		user User
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], time.Now()).Scan(
+		&key.ID,
+		&key.Name,
+		pq.Array(&key.Permissions),
+		&key.Expiry,
+		&key.CreatedAt,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	key.UserID = user.ID
+
+	return &key, &user, nil
+}
+
+// Delete revokes an API key. It returns ErrRecordNotFound if the key doesn't
+// exist or belongs to another user.
+func (m APIKeyModel) Delete(ctx context.Context, userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorSigner(t *testing.T) {
+	s := NewCursorSigner([]byte("0123456789abcdef0123456789abcdef"))
+
+	cursor := &Cursor{Sort: "-year", Value: "2016", ID: 3, Before: true, Scope: "scope"}
+	signed := s.Sign(cursor)
+
+	got, err := s.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *got != *cursor {
+		t.Errorf("got cursor %+v; want %+v", got, cursor)
+	}
+
+	payload, signature, _ := strings.Cut(signed, ".")
+
+	// resign signs a payload the way Sign does, so that only the payload is
+	// wrong.
+	resign := func(payload string) string {
+		return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+	}
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"-year","v":"1900","i":1,"b":true,"f":"scope"}`))
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"tampered payload", forged + "." + signature},
+		{"tampered signature", payload + "." + strings.Repeat("A", len(signature))},
+		{"signed with another key", NewCursorSigner([]byte("another key")).Sign(cursor)},
+		{"missing signature", payload},
+		{"empty signature", payload + "."},
+		{"malformed signature", payload + ".!!!"},
+		{"malformed payload", resign("!!!")},
+		{"payload not JSON", resign(base64.RawURLEncoding.EncodeToString([]byte("not json")))},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Parse(tt.cursor)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got cursor %+v, error %v; want %v", got, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestMovieFilterCursorScope(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	filter := MovieFilter{
+		Title:       "moana",
+		Genres:      []string{"animation", "adventure"},
+		GenresAny:   []string{"comedy", "drama"},
+		CreatedFrom: created,
+	}
+
+	scope := filter.CursorScope(1)
+
+	tests := []struct {
+		name      string
+		change    func(f *MovieFilter)
+		orgID     int64
+		wantEqual bool
+	}{
+		{"same filter", func(f *MovieFilter) {}, 1, true},
+		{"genres in another order", func(f *MovieFilter) {
+			f.Genres = []string{"adventure", "animation"}
+			f.GenresAny = []string{"drama", "comedy"}
+		}, 1, true},
+		{"same instant in another time zone", func(f *MovieFilter) {
+			f.CreatedFrom = created.In(time.FixedZone("UTC+2", 2*60*60))
+		}, 1, true},
+		{"other title", func(f *MovieFilter) { f.Title = "moana 2" }, 1, false},
+		{"fewer genres", func(f *MovieFilter) { f.Genres = []string{"animation"} }, 1, false},
+		{"other creation time", func(f *MovieFilter) { f.CreatedFrom = created.Add(time.Second) }, 1, false},
+		{"other organization", func(f *MovieFilter) {}, 2, false},
+		{"shared catalog", func(f *MovieFilter) {}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := filter
+			f.Genres = append([]string(nil), filter.Genres...)
+			f.GenresAny = append([]string(nil), filter.GenresAny...)
+			tt.change(&f)
+
+			if got := f.CursorScope(tt.orgID) == scope; got != tt.wantEqual {
+				t.Errorf("got equal scopes %t; want %t", got, tt.wantEqual)
+			}
+		})
+	}
+
+	// Sorting the genres for the scope leaves the filter itself alone.
+	if filter.Genres[0] != "animation" {
+		t.Errorf("got genres %v; want them unchanged", filter.Genres)
+	}
+}
+
+func TestMetadataSignCursors(t *testing.T) {
+	s := NewCursorSigner([]byte("0123456789abcdef0123456789abcdef"))
+
+	metadata := Metadata{
+		next: &Cursor{Sort: "title", Value: "B", ID: 2},
+		prev: &Cursor{Sort: "title", Value: "A", ID: 1, Before: true},
+	}
+
+	metadata.SignCursors(s, "scope")
+
+	for name, signed := range map[string]string{"next": metadata.NextCursor, "prev": metadata.PrevCursor} {
+		cursor, err := s.Parse(signed)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if cursor.Scope != "scope" {
+			t.Errorf("%s: got scope %q; want %q", name, cursor.Scope, "scope")
+		}
+	}
+
+	// Without cursors to sign the metadata stays empty.
+	var empty Metadata
+	empty.SignCursors(s, "scope")
+
+	if empty.NextCursor != "" || empty.PrevCursor != "" {
+		t.Errorf("got cursors %q and %q; want none", empty.NextCursor, empty.PrevCursor)
+	}
+}
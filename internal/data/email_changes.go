@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EmailChangeRepository is the set of operations the application performs on
+// pending email address changes. A change is held by an email-change token,
+// which is sent to the new address; the user's email is only updated once the
+// token comes back.
+type EmailChangeRepository interface {
+	New(ctx context.Context, userID int64, email string, ttl time.Duration) (*Token, error)
+	GetForToken(ctx context.Context, tokenPlaintext string) (*User, string, error)
+}
+
+type EmailChangeModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// New creates an email-change token for changing the user's address to email,
+// replacing any earlier change which hasn't been confirmed.
+func (m EmailChangeModel) New(ctx context.Context, userID int64, email string, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, userID, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertToken(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO email_changes (hash, email) VALUES ($1, $2)`, token.Hash, email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// GetForToken returns the user holding an unexpired email-change token, along
+// with the new email address it was issued for.
+func (m EmailChangeModel) GetForToken(ctx context.Context, tokenPlaintext string) (*User, string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email,
+		users.password_hash, users.activated, users.version, email_changes.email
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		INNER JOIN email_changes
+		ON email_changes.hash = tokens.hash
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3`
+
+	var (
+		user  User
+		email string
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeEmailChange, time.Now()).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&email,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, "", ErrRecordNotFound
+		default:
+			return nil, "", err
+		}
+	}
+
+	return &user, email, nil
+}
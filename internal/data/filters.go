@@ -1,24 +1,40 @@
 package data
 
 import (
+	"fmt"
 	"greenlight/internal/validator"
 	"math"
+	"slices"
 	"strings"
 )
 
+// Filters selects a page of a sorted listing. Without a Cursor the page is
+// picked by its number, which is simple but means that every row before it has
+// to be read. With a Cursor the page starts right after (or ends right before)
+// the row the cursor marks, and the Page is ignored. The total number of
+// records is only counted if IncludeTotal is set.
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Cursor       *Cursor
+	IncludeTotal bool
 }
 
+// Metadata describes the page returned by a listing. NextCursor and
+// PrevCursor are only filled in by SignCursors.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+
+	next *Cursor
+	prev *Cursor
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -30,6 +46,11 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// Check that the sort paramter matches a value in the safelist.
 	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	// A cursor only marks a position in the order it was created for.
+	if f.Cursor != nil {
+		v.Check(f.Cursor.Sort == f.Sort, "sort", "must match the sort of the cursor")
+	}
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -69,5 +90,123 @@ func (f Filters) limit() int {
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != nil {
+		return 0
+	}
+
 	return (f.Page - 1) * f.PageSize
 }
+
+// orderBy returns the ORDER BY clause of a listing, with the ID as the tie
+// breaker. The order is reversed when reading the rows before a cursor, as
+// those closest to the cursor have to be read first.
+func (f Filters) orderBy() string {
+	direction, idDirection := f.sortDirection(), "ASC"
+
+	if f.Cursor != nil && f.Cursor.Before {
+		direction, idDirection = reverseDirection(direction), reverseDirection(idDirection)
+	}
+
+	return fmt.Sprintf("%s %s, id %s", f.sortColumn(), direction, idDirection)
+}
+
+// keysetCondition returns the condition which selects the rows after (or
+// before) the cursor, given the expression the rows are sorted by and the
+// placeholders of the cursor's value and ID. The leading bound on the sort
+// expression is implied by the rest, but lets PostgreSQL start an index scan
+// at the cursor.
+func (f Filters) keysetCondition(column, valueParam, idParam string) string {
+	operator, idOperator := ">", ">"
+	if f.sortDirection() == "DESC" {
+		operator = "<"
+	}
+
+	if f.Cursor.Before {
+		operator, idOperator = reverseOperator(operator), reverseOperator(idOperator)
+	}
+
+	return fmt.Sprintf("%[1]s %[2]s= %[3]s AND (%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[4]s %[5]s))",
+		column, operator, valueParam, idOperator, idParam)
+}
+
+func reverseDirection(direction string) string {
+	if direction == "DESC" {
+		return "ASC"
+	}
+
+	return "DESC"
+}
+
+func reverseOperator(operator string) string {
+	if operator == "<" {
+		return ">"
+	}
+
+	return "<"
+}
+
+// paginate turns the rows read for a page into the page and its metadata. The
+// rows must be in the order given by orderBy, and include one more row than
+// the page size if there is one, which tells whether another page follows.
+// position returns the sort column value and ID of a row.
+func paginate[T any](f Filters, rows []T, totalRecords int, position func(T) (string, int64)) ([]T, Metadata) {
+	more := len(rows) > f.limit()
+	if more {
+		rows = rows[:f.limit()]
+	}
+
+	var metadata Metadata
+
+	if f.Cursor == nil {
+		// Keep the metadata of offset paging as it always was. The total isn't
+		// known when the requested page is empty.
+		if len(rows) == 0 {
+			return rows, Metadata{}
+		}
+
+		if f.IncludeTotal {
+			metadata = calculateMetadata(totalRecords, f.Page, f.PageSize)
+		} else {
+			metadata = Metadata{CurrentPage: f.Page, PageSize: f.PageSize}
+		}
+	} else {
+		metadata = Metadata{PageSize: f.PageSize, TotalRecords: totalRecords}
+
+		if f.Cursor.Before {
+			slices.Reverse(rows)
+		}
+	}
+
+	if len(rows) == 0 {
+		return rows, metadata
+	}
+
+	cursorAt := func(row T, before bool) *Cursor {
+		value, id := position(row)
+		return &Cursor{Sort: f.Sort, Value: value, ID: id, Before: before}
+	}
+
+	// Reading the rows before a cursor tells whether there are more of them,
+	// while there is always at least the cursor's row after them; and the
+	// other way round when reading the rows after a cursor.
+	var hasNext, hasPrev bool
+
+	switch {
+	case f.Cursor == nil:
+		hasNext, hasPrev = more, f.Page > 1
+	case f.Cursor.Before:
+		hasNext, hasPrev = true, more
+	default:
+		hasNext, hasPrev = more, true
+	}
+
+	if hasNext {
+		metadata.next = cursorAt(rows[len(rows)-1], false)
+	}
+
+	if hasPrev {
+		metadata.prev = cursorAt(rows[0], true)
+	}
+
+	return rows, metadata
+}
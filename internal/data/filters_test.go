@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		sort   string
+		before bool
+		want   string
+	}{
+		{"title", false, "title >= $1 AND (title > $1 OR (title = $1 AND id > $2))"},
+		{"title", true, "title <= $1 AND (title < $1 OR (title = $1 AND id < $2))"},
+		{"-title", false, "title <= $1 AND (title < $1 OR (title = $1 AND id > $2))"},
+		{"-title", true, "title >= $1 AND (title > $1 OR (title = $1 AND id < $2))"},
+	}
+
+	for _, tt := range tests {
+		f := Filters{Sort: tt.sort, SortSafeList: movieSortSafeList, Cursor: &Cursor{Sort: tt.sort, Before: tt.before}}
+
+		if got := f.keysetCondition("title", "$1", "$2"); got != tt.want {
+			t.Errorf("%s, before %t: got %q; want %q", tt.sort, tt.before, got, tt.want)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		sort   string
+		cursor *Cursor
+		want   string
+	}{
+		{"year", nil, "year ASC, id ASC"},
+		{"-year", nil, "year DESC, id ASC"},
+		{"year", &Cursor{Sort: "year"}, "year ASC, id ASC"},
+		{"-year", &Cursor{Sort: "-year"}, "year DESC, id ASC"},
+		{"year", &Cursor{Sort: "year", Before: true}, "year DESC, id DESC"},
+		{"-year", &Cursor{Sort: "-year", Before: true}, "year ASC, id DESC"},
+	}
+
+	for _, tt := range tests {
+		f := Filters{Sort: tt.sort, SortSafeList: movieSortSafeList, Cursor: tt.cursor}
+
+		if got := f.orderBy(); got != tt.want {
+			t.Errorf("%s, cursor %+v: got %q; want %q", tt.sort, tt.cursor, got, tt.want)
+		}
+	}
+}
+
+// TestCursorPagingOverEqualKeys pages forwards to the end of a listing whose
+// sort column has runs of equal values, then backwards to the start, checking
+// that the ID breaks the ties the same way in both directions so that no
+// movie is skipped or repeated.
+func TestCursorPagingOverEqualKeys(t *testing.T) {
+	models := newTestModels(t)
+	s := NewCursorSigner([]byte("0123456789abcdef0123456789abcdef"))
+
+	for _, year := range []int32{2001, 2000, 2001, 2000, 2002, 2001, 2000} {
+		insertTestMovies(t, models, &Movie{Title: "Movie", Year: year, Runtime: 90, Genres: []string{"drama"}})
+	}
+
+	tests := []struct {
+		sort string
+		want [][]int64
+	}{
+		{"year", [][]int64{{2, 4, 7}, {1, 3, 6}, {5}}},
+		{"-year", [][]int64{{5, 1, 3}, {6, 2, 4}, {7}}},
+		{"title", [][]int64{{1, 2, 3}, {4, 5, 6}, {7}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			// page reads the page the signed cursor points to, or the first
+			// page if there is none.
+			page := func(signed string) ([]int64, Metadata) {
+				t.Helper()
+
+				filters := Filters{Page: 1, PageSize: 3, Sort: tt.sort, SortSafeList: movieSortSafeList}
+
+				if signed != "" {
+					cursor, err := s.Parse(signed)
+					if err != nil {
+						t.Fatal(err)
+					}
+					filters.Cursor = cursor
+				}
+
+				movies, metadata, err := models.Movies.GetAll(context.Background(), 0, MovieFilter{}, filters)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				metadata.SignCursors(s, "")
+
+				return movieIDs(movies), metadata
+			}
+
+			got, metadata := page("")
+			if !slices.Equal(got, tt.want[0]) || metadata.PrevCursor != "" {
+				t.Fatalf("first page: got %v, prev cursor %q; want %v and none", got, metadata.PrevCursor, tt.want[0])
+			}
+
+			for i := 1; i < len(tt.want); i++ {
+				got, metadata = page(metadata.NextCursor)
+				if !slices.Equal(got, tt.want[i]) {
+					t.Fatalf("forwards to page %d: got %v; want %v", i+1, got, tt.want[i])
+				}
+			}
+
+			if metadata.NextCursor != "" {
+				t.Errorf("last page: got next cursor %q; want none", metadata.NextCursor)
+			}
+
+			for i := len(tt.want) - 2; i >= 0; i-- {
+				got, metadata = page(metadata.PrevCursor)
+				if !slices.Equal(got, tt.want[i]) {
+					t.Fatalf("backwards to page %d: got %v; want %v", i+1, got, tt.want[i])
+				}
+			}
+
+			if metadata.PrevCursor != "" {
+				t.Errorf("first page again: got prev cursor %q; want none", metadata.PrevCursor)
+			}
+		})
+	}
+}
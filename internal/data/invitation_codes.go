@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"greenlight/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidInvitationCode = errors.New("invalid invitation code")
+
+// InvitationCode lets people register while registration is invite-only. A
+// code can be bound to a single email address, is valid for a limited number of
+// registrations until it expires, and grants its permissions to every user who
+// registers with it.
+type InvitationCode struct {
+	ID          int64       `json:"id"`
+	Plaintext   string      `json:"code,omitempty"`
+	Hash        []byte      `json:"-"`
+	Email       string      `json:"email,omitempty"`
+	MaxUses     int         `json:"max_uses"`
+	Uses        int         `json:"uses"`
+	Permissions Permissions `json:"permissions"`
+	CreatedBy   *int64      `json:"created_by"`
+	Expiry      time.Time   `json:"expiry"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+func ValidateInvitationCode(v *validator.Validator, code *InvitationCode) {
+	if code.Email != "" {
+		v.Check(validator.Matches(code.Email, validator.EmailRX), "email", "must be a valid email adress")
+	}
+
+	v.Check(code.MaxUses >= 1, "max_uses", "must be greater than zero")
+	v.Check(code.MaxUses <= 10_000, "max_uses", "must not be more than 10000")
+
+	v.Check(validator.Unique(code.Permissions), "permissions", "must not contain duplicate values")
+
+	v.Check(code.Expiry.After(time.Now()), "expiry", "must be in the future")
+}
+
+// Check that the plaintext invitation code is exactly 26 bytes long, like the
+// tokens it is generated in the same way as.
+func ValidateInvitationCodePlaintext(v *validator.Validator, codePlaintext string) {
+	v.Check(codePlaintext != "", "invitation_code", "must be provided")
+	v.Check(len(codePlaintext) == 26, "invitation_code", "must be 26 bytes long")
+}
+
+// InvitationCodeRepository is the set of operations the application performs on
+// invitation codes.
+type InvitationCodeRepository interface {
+	New(ctx context.Context, code *InvitationCode) error
+	GetAll(ctx context.Context) ([]*InvitationCode, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type InvitationCodeModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// generateInvitationCode fills in the plaintext and hash of a code. The code is
+// generated in the same way as a token, and only its hash is stored.
+func generateInvitationCode(code *InvitationCode) error {
+	token, err := generateToken(0, 0, "")
+	if err != nil {
+		return err
+	}
+
+	code.Plaintext = token.Plaintext
+	code.Hash = token.Hash
+
+	return nil
+}
+
+// New generates the plaintext of the code and stores the code.
+func (m InvitationCodeModel) New(ctx context.Context, code *InvitationCode) error {
+	err := generateInvitationCode(code)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO invitation_codes (hash, email, max_uses, permissions, created_by, expiry)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+		RETURNING id, uses, created_at`
+
+	args := []interface{}{code.Hash, code.Email, code.MaxUses, pq.Array(code.Permissions), code.CreatedBy, code.Expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&code.ID, &code.Uses, &code.CreatedAt)
+}
+
+// GetAll returns every invitation code, including used up and expired ones,
+// oldest first. The plaintext codes are never returned.
+func (m InvitationCodeModel) GetAll(ctx context.Context) ([]*InvitationCode, error) {
+	query := `
+		SELECT id, COALESCE(email, ''), max_uses, uses, permissions, created_by, expiry, created_at
+		FROM invitation_codes
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	codes := []*InvitationCode{}
+
+	for rows.Next() {
+		var code InvitationCode
+
+		err := rows.Scan(
+			&code.ID,
+			&code.Email,
+			&code.MaxUses,
+			&code.Uses,
+			pq.Array(&code.Permissions),
+			&code.CreatedBy,
+			&code.Expiry,
+			&code.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, &code)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Delete revokes an invitation code. Users who have already registered with it
+// keep their permissions.
+func (m InvitationCodeModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM invitation_codes
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// redeemInvitationCode uses up one of the registrations a code is valid for, on
+// behalf of the given email address. It returns ErrInvalidInvitationCode if the
+// code doesn't exist, has expired, has been used up or is bound to another email
+// address. Checking and counting the use in a single statement means that
+// concurrent registrations can't use a code more often than allowed.
+func redeemInvitationCode(ctx context.Context, db queryRower, codePlaintext, email string) (*InvitationCode, error) {
+	codeHash := sha256.Sum256([]byte(codePlaintext))
+
+	query := `
+		UPDATE invitation_codes
+		SET uses = uses + 1
+		WHERE hash = $1
+		AND uses < max_uses
+		AND expiry > $2
+		AND (email IS NULL OR email = $3)
+		RETURNING id, COALESCE(email, ''), max_uses, uses, permissions, created_by, expiry, created_at`
+
+	var code InvitationCode
+
+	err := db.QueryRowContext(ctx, query, codeHash[:], time.Now(), email).Scan(
+		&code.ID,
+		&code.Email,
+		&code.MaxUses,
+		&code.Uses,
+		pq.Array(&code.Permissions),
+		&code.CreatedBy,
+		&code.Expiry,
+		&code.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidInvitationCode
+		default:
+			return nil, err
+		}
+	}
+
+	return &code, nil
+}
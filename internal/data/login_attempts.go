@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LoginAttempt counts the recent failed logins for a key, which is either an
+// account or a client IP address (see LoginAttemptEmailKey and
+// LoginAttemptIPKey).
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// LoginAttemptEmailKey returns the key under which failed logins for an email
+// address are counted. Failures are counted per address whether or not it is
+// registered, so that a lockout doesn't reveal which addresses are.
+func LoginAttemptEmailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// LoginAttemptIPKey returns the key under which failed logins from an IP
+// address are counted.
+func LoginAttemptIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LockoutPolicy decides when repeated failed logins lock a key. Once Threshold
+// failures have been counted the key is locked for BaseDelay, and the lock
+// doubles with every further failure up to MaxDelay. The count starts again
+// after a quiet period of Window without failures.
+type LockoutPolicy struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+// Delay returns how long a key is locked for after the given number of
+// failures, which is zero below the threshold.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if p.Threshold < 1 || failures < p.Threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// LoginAttemptRepository is the set of operations the application performs on
+// failed login counters.
+type LoginAttemptRepository interface {
+	LockedUntil(ctx context.Context, keys ...string) (time.Time, error)
+	RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (*LoginAttempt, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type LoginAttemptModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// LockedUntil returns the time until which the most restrictive of the given
+// keys is locked, or the zero time if none of them are.
+func (m LoginAttemptModel) LockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	query := `
+		SELECT max(locked_until)
+		FROM login_attempts
+		WHERE key = ANY($1) AND locked_until > $2`
+
+	var lockedUntil *time.Time
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys), time.Now()).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+
+	return *lockedUntil, nil
+}
+
+// RecordFailure counts a failed login for the key and locks it if the policy
+// says so. The counter row stays locked until the transaction commits, so that
+// concurrent failures on different API instances are all counted.
+func (m LoginAttemptModel) RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (*LoginAttempt, error) {
+	now := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failure_at < $3 THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures, last_failure_at, locked_until`
+
+	attempt := LoginAttempt{Key: key}
+
+	err = tx.QueryRowContext(ctx, query, key, now, now.Add(-policy.Window)).Scan(
+		&attempt.Failures,
+		&attempt.LastFailureAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if delay := policy.Delay(attempt.Failures); delay > 0 {
+		lockedUntil := now.Add(delay)
+		attempt.LockedUntil = &lockedUntil
+
+		_, err = tx.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $1 WHERE key = $2`,
+			lockedUntil, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// Reset clears the failed logins and any lock for the key.
+func (m LoginAttemptModel) Reset(ctx context.Context, key string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	tests := []struct {
+		name     string
+		policy   LockoutPolicy
+		failures int
+		want     time.Duration
+	}{
+		{"no failures", policy, 0, 0},
+		{"below the threshold", policy, 4, 0},
+		{"at the threshold", policy, 5, time.Minute},
+		{"one past the threshold", policy, 6, 2 * time.Minute},
+		{"two past the threshold", policy, 7, 4 * time.Minute},
+		{"last doubling under the cap", policy, 10, 32 * time.Minute},
+		{"first doubling over the cap", policy, 11, time.Hour},
+		{"far past the cap", policy, 1 << 30, time.Hour},
+		{"cap between doublings", LockoutPolicy{Threshold: 1, BaseDelay: time.Minute, MaxDelay: 90 * time.Minute}, 8, 90 * time.Minute},
+		{"base delay over the cap", LockoutPolicy{Threshold: 1, BaseDelay: 2 * time.Hour, MaxDelay: time.Hour}, 1, time.Hour},
+		{"threshold of one", LockoutPolicy{Threshold: 1, BaseDelay: time.Second, MaxDelay: time.Hour}, 1, time.Second},
+		{"disabled", LockoutPolicy{Threshold: 0, BaseDelay: time.Minute, MaxDelay: time.Hour}, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Delay(tt.failures); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// These errors stand in for the constraint violations that PostgreSQL reports
+// and which the SQL models pass straight through to the caller.
+var (
+	errForeignKeyViolation = errors.New("memory: foreign key violation")
+	errUniqueViolation     = errors.New("memory: unique violation")
+)
+
+// ErrUnsupportedSearchConfig is returned by NewMemoryModels for a text search
+// configuration that the in-memory models can't search like PostgreSQL does.
+var ErrUnsupportedSearchConfig = errors.New("memory: unsupported text search configuration")
+
+// memoryStore holds the state shared by the in-memory repositories. It plays
+// the part of the database: the repositories read and write it under a single
+// mutex, and only ever hand out copies of the records it holds.
+type memoryStore struct {
+	mu sync.Mutex
+
+	movies      map[int64]*Movie
+	nextMovieID int64
+
+	users      map[int64]*User
+	nextUserID int64
+
+	// tokens is keyed by the string form of the token hash, mirroring the
+	// primary key of the tokens table.
+	tokens map[string]*Token
+
+	// emailChanges maps the string form of an email-change token hash to the
+	// new email address, mirroring the email_changes table.
+	emailChanges map[string]string
+
+	apiKeys      map[int64]*APIKey
+	nextAPIKeyID int64
+
+	// totp is keyed by user ID, and recoveryCodes maps the string form of a
+	// recovery code hash to the ID of the user it belongs to.
+	totp          map[int64]*TOTP
+	recoveryCodes map[string]int64
+
+	// loginAttempts is keyed by the login attempt key.
+	loginAttempts map[string]*LoginAttempt
+
+	// permissions is the list of known permission codes, and userPermissions
+	// maps a user ID to the codes granted to that user directly.
+	permissions     []string
+	userPermissions map[int64]map[string]bool
+
+	// roles is keyed by role ID, and userRoles maps a user ID to the IDs of
+	// the roles assigned to that user.
+	roles      map[int64]*Role
+	nextRoleID int64
+	userRoles  map[int64]map[int64]bool
+
+	// organizationMembers maps an organization ID to its members keyed by user
+	// ID, organizationInvitations is keyed by the string form of the invitation
+	// hash, and activeOrganizations maps a user ID to the ID of their active
+	// organization.
+	organizations           map[int64]*Organization
+	nextOrganizationID      int64
+	organizationMembers     map[int64]map[int64]*Membership
+	organizationInvitations map[string]*OrganizationInvitation
+	activeOrganizations     map[int64]int64
+
+	invitationCodes      map[int64]*InvitationCode
+	nextInvitationCodeID int64
+}
+
+// NewMemoryModels returns a Models struct whose repositories keep their data
+// in memory. They follow the same rules as the PostgreSQL models, including
+// optimistic locking and the errors returned, which makes them suitable for
+// exercising the handlers without a database.
+//
+// Movie titles are searched like PostgreSQL does with the 'simple' text search
+// configuration, which lower-cases words but neither stems them nor drops stop
+// words. Any other searchConfig is refused with ErrUnsupportedSearchConfig
+// rather than searched differently from the database.
+func NewMemoryModels(searchConfig string) (Models, error) {
+	if searchConfig != "simple" {
+		return Models{}, fmt.Errorf("%w: %q", ErrUnsupportedSearchConfig, searchConfig)
+	}
+
+	store := &memoryStore{
+		movies:          make(map[int64]*Movie),
+		users:           make(map[int64]*User),
+		tokens:          make(map[string]*Token),
+		emailChanges:    make(map[string]string),
+		apiKeys:         make(map[int64]*APIKey),
+		totp:            make(map[int64]*TOTP),
+		recoveryCodes:   make(map[string]int64),
+		loginAttempts:   make(map[string]*LoginAttempt),
+		permissions:     []string{"movies:read", "movies:write", "users:admin", "movies:admin"},
+		userPermissions: make(map[int64]map[string]bool),
+		roles:           make(map[int64]*Role),
+		userRoles:       make(map[int64]map[int64]bool),
+
+		organizations:           make(map[int64]*Organization),
+		organizationMembers:     make(map[int64]map[int64]*Membership),
+		organizationInvitations: make(map[string]*OrganizationInvitation),
+		activeOrganizations:     make(map[int64]int64),
+
+		invitationCodes: make(map[int64]*InvitationCode),
+	}
+
+	// Seed the same roles as the migrations do.
+	for _, role := range []*Role{
+		{Name: "viewer", Permissions: Permissions{"movies:read"}},
+		{Name: "editor", Permissions: Permissions{"movies:read", "movies:write"}},
+		{Name: "admin", Permissions: Permissions{"movies:read", "movies:write", "users:admin", "movies:admin"}},
+	} {
+		store.nextRoleID++
+		role.ID = store.nextRoleID
+		role.CreatedAt = store.now()
+		store.roles[role.ID] = role
+	}
+
+	return Models{
+		APIKeys:                 memoryAPIKeyModel{store: store},
+		EmailChanges:            memoryEmailChangeModel{store: store},
+		InvitationCodes:         memoryInvitationCodeModel{store: store},
+		LoginAttempts:           memoryLoginAttemptModel{store: store},
+		MFA:                     memoryMFAModel{store: store},
+		Movies:                  memoryMovieModel{store: store},
+		Organizations:           memoryOrganizationModel{store: store},
+		OrganizationInvitations: memoryOrganizationInvitationModel{store: store},
+		Permissions:             memoryPermissionModel{store: store},
+		Roles:                   memoryRoleModel{store: store},
+		Tokens:                  memoryTokenModel{store: store},
+		Users:                   memoryUserModel{store: store},
+	}, nil
+}
+
+// lock acquires the store mutex, unless the context is already done, in which
+// case the context error is returned just like a cancelled query would.
+func (s *memoryStore) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	return nil
+}
+
+func (s *memoryStore) unlock() {
+	s.mu.Unlock()
+}
+
+// now returns the current time truncated to whole seconds, matching the
+// timestamp(0) columns used in the database schema.
+func (s *memoryStore) now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"sort"
+	"time"
+)
+
+type memoryAPIKeyModel struct {
+	store *memoryStore
+}
+
+func (m memoryAPIKeyModel) New(ctx context.Context, userID int64, name string, permissions Permissions,
+	expiry *time.Time) (*APIKey, error) {
+
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, key)
+	return key, err
+}
+
+func (m memoryAPIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.users[key.UserID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	m.store.nextAPIKeyID++
+
+	key.ID = m.store.nextAPIKeyID
+	key.CreatedAt = m.store.now()
+
+	m.store.apiKeys[key.ID] = copyAPIKey(key)
+
+	return nil
+}
+
+func (m memoryAPIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	keys := []*APIKey{}
+
+	for _, key := range m.store.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys, nil
+}
+
+func (m memoryAPIKeyModel) GetForPlaintext(ctx context.Context, keyPlaintext string) (*APIKey, *User, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer m.store.unlock()
+
+	now := m.store.now()
+	for _, key := range m.store.apiKeys {
+		if string(key.Hash) != string(keyHash[:]) {
+			continue
+		}
+
+		if key.Expiry != nil && !key.Expiry.After(now) {
+			break
+		}
+
+		user, ok := m.store.users[key.UserID]
+		if !ok {
+			break
+		}
+
+		return copyAPIKey(key), copyUser(user), nil
+	}
+
+	return nil, nil, ErrRecordNotFound
+}
+
+func (m memoryAPIKeyModel) Delete(ctx context.Context, userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	key, ok := m.store.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.apiKeys, id)
+
+	return nil
+}
+
+// copyAPIKey returns a copy of the key without its plaintext, as a key read back
+// from the database wouldn't have one.
+func copyAPIKey(key *APIKey) *APIKey {
+	c := *key
+	c.Plaintext = ""
+	c.Permissions = append(Permissions{}, key.Permissions...)
+	if key.Expiry != nil {
+		expiry := key.Expiry.Truncate(time.Second)
+		c.Expiry = &expiry
+	}
+	return &c
+}
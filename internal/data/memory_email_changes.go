@@ -0,0 +1,62 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+)
+
+type memoryEmailChangeModel struct {
+	store *memoryStore
+}
+
+func (m memoryEmailChangeModel) New(ctx context.Context, userID int64, email string, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	m.store.deleteTokens(func(t *Token) bool {
+		return t.UserID == userID && t.Scope == ScopeEmailChange
+	})
+
+	err = m.store.insertToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	m.store.emailChanges[string(token.Hash)] = email
+
+	return token, nil
+}
+
+func (m memoryEmailChangeModel) GetForToken(ctx context.Context, tokenPlaintext string) (*User, string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, "", err
+	}
+	defer m.store.unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != ScopeEmailChange || !token.Expiry.After(m.store.now()) {
+		return nil, "", ErrRecordNotFound
+	}
+
+	email, ok := m.store.emailChanges[string(tokenHash[:])]
+	if !ok {
+		return nil, "", ErrRecordNotFound
+	}
+
+	user, ok := m.store.users[token.UserID]
+	if !ok {
+		return nil, "", ErrRecordNotFound
+	}
+
+	return copyUser(user), email, nil
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"time"
+)
+
+type memoryInvitationCodeModel struct {
+	store *memoryStore
+}
+
+func (m memoryInvitationCodeModel) New(ctx context.Context, code *InvitationCode) error {
+	err := generateInvitationCode(code)
+	if err != nil {
+		return err
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if code.CreatedBy != nil {
+		if _, ok := m.store.users[*code.CreatedBy]; !ok {
+			return errForeignKeyViolation
+		}
+	}
+
+	m.store.nextInvitationCodeID++
+
+	code.ID = m.store.nextInvitationCodeID
+	code.Uses = 0
+	code.CreatedAt = m.store.now()
+
+	m.store.invitationCodes[code.ID] = copyInvitationCode(code)
+
+	return nil
+}
+
+func (m memoryInvitationCodeModel) GetAll(ctx context.Context) ([]*InvitationCode, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	codes := []*InvitationCode{}
+
+	for _, code := range m.store.invitationCodes {
+		codes = append(codes, copyInvitationCode(code))
+	}
+
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].ID < codes[j].ID
+	})
+
+	return codes, nil
+}
+
+func (m memoryInvitationCodeModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.invitationCodes[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.invitationCodes, id)
+
+	return nil
+}
+
+// redeemableInvitationCode returns the stored code with the given plaintext if
+// the email address may still register with it, or nil otherwise.
+func (s *memoryStore) redeemableInvitationCode(codePlaintext, email string) *InvitationCode {
+	codeHash := sha256.Sum256([]byte(codePlaintext))
+
+	for _, code := range s.invitationCodes {
+		if string(code.Hash) != string(codeHash[:]) {
+			continue
+		}
+
+		if code.Uses >= code.MaxUses || !code.Expiry.After(time.Now()) {
+			return nil
+		}
+
+		if code.Email != "" && !strings.EqualFold(code.Email, email) {
+			return nil
+		}
+
+		return code
+	}
+
+	return nil
+}
+
+// copyInvitationCode returns a copy of the code without its plaintext, as a
+// code read back from the database wouldn't have one.
+func copyInvitationCode(code *InvitationCode) *InvitationCode {
+	c := *code
+	c.Plaintext = ""
+	c.Permissions = append(Permissions{}, code.Permissions...)
+	c.Expiry = code.Expiry.Truncate(time.Second)
+	if code.CreatedBy != nil {
+		createdBy := *code.CreatedBy
+		c.CreatedBy = &createdBy
+	}
+	return &c
+}
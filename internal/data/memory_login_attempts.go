@@ -0,0 +1,74 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type memoryLoginAttemptModel struct {
+	store *memoryStore
+}
+
+func (m memoryLoginAttemptModel) LockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return time.Time{}, err
+	}
+	defer m.store.unlock()
+
+	var lockedUntil time.Time
+
+	now := time.Now()
+	for _, key := range keys {
+		attempt, ok := m.store.loginAttempts[key]
+		if !ok || attempt.LockedUntil == nil || !attempt.LockedUntil.After(now) {
+			continue
+		}
+
+		if attempt.LockedUntil.After(lockedUntil) {
+			lockedUntil = *attempt.LockedUntil
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+func (m memoryLoginAttemptModel) RecordFailure(ctx context.Context, key string, policy LockoutPolicy) (*LoginAttempt, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	now := time.Now()
+
+	attempt, ok := m.store.loginAttempts[key]
+	if !ok {
+		attempt = &LoginAttempt{Key: key}
+		m.store.loginAttempts[key] = attempt
+	}
+
+	if attempt.LastFailureAt.Before(now.Add(-policy.Window)) {
+		attempt.Failures = 0
+	}
+
+	attempt.Failures++
+	attempt.LastFailureAt = now.Truncate(time.Second)
+
+	if delay := policy.Delay(attempt.Failures); delay > 0 {
+		lockedUntil := now.Add(delay).Truncate(time.Second)
+		attempt.LockedUntil = &lockedUntil
+	}
+
+	c := *attempt
+	return &c, nil
+}
+
+func (m memoryLoginAttemptModel) Reset(ctx context.Context, key string) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	delete(m.store.loginAttempts, key)
+
+	return nil
+}
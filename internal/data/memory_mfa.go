@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+)
+
+type memoryMFAModel struct {
+	store *memoryStore
+}
+
+func (m memoryMFAModel) GetTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	totp, ok := m.store.totp[userID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyTOTP(totp), nil
+}
+
+func (m memoryMFAModel) SetTOTP(ctx context.Context, userID int64, secret []byte) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.users[userID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	if totp, ok := m.store.totp[userID]; ok && totp.Confirmed {
+		return ErrTOTPEnabled
+	}
+
+	m.store.totp[userID] = copyTOTP(&TOTP{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: m.store.now(),
+	})
+
+	return nil
+}
+
+func (m memoryMFAModel) ConfirmTOTP(ctx context.Context, userID int64, step int64) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	totp, ok := m.store.totp[userID]
+	if !ok || totp.Confirmed {
+		return nil, ErrRecordNotFound
+	}
+
+	totp.Confirmed = true
+	totp.LastUsedStep = step
+
+	m.store.deleteRecoveryCodes(userID)
+	for _, hash := range hashes {
+		m.store.recoveryCodes[string(hash)] = userID
+	}
+
+	return codes, nil
+}
+
+func (m memoryMFAModel) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	totp, ok := m.store.totp[userID]
+	if !ok || !totp.Confirmed || totp.LastUsedStep >= step {
+		return ErrTOTPCodeReused
+	}
+
+	totp.LastUsedStep = step
+
+	return nil
+}
+
+func (m memoryMFAModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	hash := string(hashRecoveryCode(code))
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if owner, ok := m.store.recoveryCodes[hash]; !ok || owner != userID {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.recoveryCodes, hash)
+
+	return nil
+}
+
+func (m memoryMFAModel) DeleteTOTP(ctx context.Context, userID int64) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	m.store.deleteRecoveryCodes(userID)
+	delete(m.store.totp, userID)
+
+	return nil
+}
+
+// deleteRecoveryCodes deletes every recovery code belonging to the user. It must
+// be called with the store lock held.
+func (s *memoryStore) deleteRecoveryCodes(userID int64) {
+	for hash, owner := range s.recoveryCodes {
+		if owner == userID {
+			delete(s.recoveryCodes, hash)
+		}
+	}
+}
+
+func copyTOTP(totp *TOTP) *TOTP {
+	c := *totp
+	c.Secret = append([]byte(nil), totp.Secret...)
+	return &c
+}
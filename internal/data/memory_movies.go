@@ -0,0 +1,775 @@
+package data
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// memoryMovieModel matches titles like the 'simple' text search configuration,
+// the only one NewMemoryModels accepts.
+type memoryMovieModel struct {
+	store *memoryStore
+}
+
+func (m memoryMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	m.store.nextMovieID++
+
+	movie.ID = m.store.nextMovieID
+	movie.CreatedAt = m.store.now()
+	movie.Version = 1
+
+	m.store.movies[movie.ID] = copyMovie(movie)
+
+	return nil
+}
+
+func (m memoryMovieModel) Get(ctx context.Context, orgID, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	movie, ok := m.store.movies[id]
+	if !ok || movie.OrganizationID != orgID {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyMovie(movie), nil
+}
+
+func (m memoryMovieModel) Update(ctx context.Context, movie *Movie) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	// Apply the same optimistic locking rule as the SQL query: the update only
+	// goes through if the record still exists with the version the caller read.
+	existing, ok := m.store.movies[movie.ID]
+	if !ok || existing.Version != movie.Version || existing.OrganizationID != movie.OrganizationID {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+
+	updated := copyMovie(movie)
+	updated.CreatedAt = existing.CreatedAt
+	updated.CreatedBy = existing.CreatedBy
+	m.store.movies[movie.ID] = updated
+
+	return nil
+}
+
+func (m memoryMovieModel) Delete(ctx context.Context, orgID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if movie, ok := m.store.movies[id]; !ok || movie.OrganizationID != orgID {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.movies, id)
+
+	return nil
+}
+
+func (m memoryMovieModel) GetAll(ctx context.Context, orgID int64, filter MovieFilter,
+	filters Filters) ([]*Movie, Metadata, error) {
+
+	// Resolve the sort column up front so that an unsafe value panics in the
+	// same way as it does for the SQL model.
+	filters.sortColumn()
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer m.store.unlock()
+
+	return m.getAll(orgID, filter, filters)
+}
+
+// GetAllWithFacets reads the movies and the facets while holding the lock, so
+// that they agree with each other like they do in the SQL model's transaction.
+func (m memoryMovieModel) GetAllWithFacets(ctx context.Context, orgID int64, filter MovieFilter,
+	filters Filters) ([]*Movie, Metadata, *MovieFacets, error) {
+
+	filters.sortColumn()
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, Metadata{}, nil, err
+	}
+	defer m.store.unlock()
+
+	movies, metadata, err := m.getAll(orgID, filter, filters)
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	return movies, metadata, m.facets(orgID, filter), nil
+}
+
+// getAll and facets expect the caller to hold the lock.
+func (m memoryMovieModel) getAll(orgID int64, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	query := parseWebsearchQuery(filter.Search)
+
+	matches := []*Movie{}
+
+	for _, movie := range m.store.movies {
+		if movie.OrganizationID != orgID || !filter.matches(movie) {
+			continue
+		}
+
+		if filter.Title != "" && !matchesPlainQuery(movie.Title, filter.Title) {
+			continue
+		}
+
+		var rank float32
+		if filter.Search != "" {
+			var ok bool
+			if rank, ok = query.match(movie.Title); !ok {
+				continue
+			}
+		}
+
+		match := copyMovie(movie)
+		if filter.Search != "" {
+			match.relevance = -rank
+			match.Headline = highlightHTML(query.headline(movie.Title))
+		}
+
+		matches = append(matches, match)
+	}
+
+	// compare orders two movies like the ORDER BY clause of the SQL query,
+	// before reversing it for the rows before a cursor.
+	compare := func(a, b *Movie) int {
+		c := compareMovies(a, b, column)
+		if direction == "DESC" {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+
+		return compareOrdered(a.ID, b.ID)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return compare(matches[i], matches[j]) < 0
+	})
+
+	totalRecords := len(matches)
+
+	if filters.Cursor != nil {
+		pivot, err := filters.Cursor.movie(column)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// Keep the movies on the requested side of the cursor, closest first.
+		page := []*Movie{}
+		for _, movie := range matches {
+			c := compare(movie, pivot)
+			if (c > 0 && !filters.Cursor.Before) || (c < 0 && filters.Cursor.Before) {
+				page = append(page, movie)
+			}
+		}
+
+		if filters.Cursor.Before {
+			slices.Reverse(page)
+		}
+
+		matches = page
+	}
+
+	start := min(filters.offset(), len(matches))
+	end := min(start+filters.limit()+1, len(matches))
+
+	movies := []*Movie{}
+	for _, movie := range matches[start:end] {
+		movies = append(movies, copyMovie(movie))
+	}
+
+	// The SQL query takes the total from count(*) OVER(), which is only
+	// available when the requested page contains at least one row.
+	if !filters.IncludeTotal || (filters.Cursor == nil && len(movies) == 0) {
+		totalRecords = 0
+	}
+
+	movies, metadata := paginate(filters, movies, totalRecords, func(movie *Movie) (string, int64) {
+		return movie.sortValue(column), movie.ID
+	})
+
+	return movies, metadata, nil
+}
+
+// wordSimilarityThreshold is the default pg_trgm.word_similarity_threshold,
+// above which the <% operator matches.
+const wordSimilarityThreshold = 0.6
+
+func (m memoryMovieModel) GetSimilar(ctx context.Context, orgID int64, text string, filter MovieFilter,
+	limit int) ([]*Movie, error) {
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	type match struct {
+		movie      *Movie
+		similarity float64
+	}
+
+	matches := []match{}
+
+	for _, movie := range m.store.movies {
+		if movie.OrganizationID != orgID || !filter.matches(movie) {
+			continue
+		}
+
+		if similarity := wordSimilarity(text, movie.Title); similarity >= wordSimilarityThreshold {
+			matches = append(matches, match{movie: movie, similarity: similarity})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].similarity != matches[j].similarity {
+			return matches[i].similarity > matches[j].similarity
+		}
+		return matches[i].movie.ID < matches[j].movie.ID
+	})
+
+	movies := []*Movie{}
+	for _, match := range matches[:min(limit, len(matches))] {
+		movies = append(movies, copyMovie(match.movie))
+	}
+
+	return movies, nil
+}
+
+func (m memoryMovieModel) facets(orgID int64, filter MovieFilter) *MovieFacets {
+	query := parseWebsearchQuery(filter.Search)
+	decadeFilter, runtimeFilter := filter.facetFilters()
+
+	genres := map[string]int{}
+	decades := map[int32]int{}
+	runtimes := map[int]int{}
+
+	for _, movie := range m.store.movies {
+		if movie.OrganizationID != orgID {
+			continue
+		}
+
+		if filter.Title != "" && !matchesPlainQuery(movie.Title, filter.Title) {
+			continue
+		}
+
+		if _, ok := query.match(movie.Title); filter.Search != "" && !ok {
+			continue
+		}
+
+		if filter.matches(movie) {
+			for _, genre := range movie.Genres {
+				genres[genre]++
+			}
+		}
+
+		if decadeFilter.matches(movie) {
+			decades[movie.Year/10*10]++
+		}
+
+		if runtimeFilter.matches(movie) {
+			runtimes[runtimeBucket(movie.Runtime)]++
+		}
+	}
+
+	facets := &MovieFacets{
+		Genres:   []GenreFacet{},
+		Decades:  []DecadeFacet{},
+		Runtimes: []RuntimeFacet{},
+	}
+
+	for genre, count := range genres {
+		facets.Genres = append(facets.Genres, GenreFacet{Genre: genre, Count: count})
+	}
+
+	sort.Slice(facets.Genres, func(i, j int) bool {
+		if facets.Genres[i].Count != facets.Genres[j].Count {
+			return facets.Genres[i].Count > facets.Genres[j].Count
+		}
+		return facets.Genres[i].Genre < facets.Genres[j].Genre
+	})
+
+	for decade, count := range decades {
+		facets.Decades = append(facets.Decades, DecadeFacet{Decade: decade, Count: count})
+	}
+
+	sort.Slice(facets.Decades, func(i, j int) bool {
+		return facets.Decades[i].Decade < facets.Decades[j].Decade
+	})
+
+	for bucket := 0; bucket <= len(runtimeFacetBounds); bucket++ {
+		if count := runtimes[bucket]; count > 0 {
+			facets.Runtimes = append(facets.Runtimes, newRuntimeFacet(bucket, count))
+		}
+	}
+
+	return facets
+}
+
+func (m memoryMovieModel) Suggest(ctx context.Context, orgID int64, prefix string, limit int) ([]*MovieSuggestion, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	type match struct {
+		movie *Movie
+		score float64
+	}
+
+	// Count the catalogs carrying each title, which serves as its popularity.
+	catalogs := make(map[string]map[int64]bool)
+	for _, movie := range m.store.movies {
+		title := strings.ToLower(movie.Title)
+		if catalogs[title] == nil {
+			catalogs[title] = make(map[int64]bool)
+		}
+		catalogs[title][movie.OrganizationID] = true
+	}
+
+	matches := []match{}
+
+	for _, movie := range m.store.movies {
+		if movie.OrganizationID != orgID {
+			continue
+		}
+
+		similarity := wordSimilarity(prefix, movie.Title)
+
+		if !strings.HasPrefix(strings.ToLower(movie.Title), strings.ToLower(prefix)) &&
+			similarity < wordSimilarityThreshold {
+			continue
+		}
+
+		// Rank the same way as the SQL query: the logarithm of the number of
+		// catalogs divided by 20 is the bonus for popularity.
+		popularity := float64(len(catalogs[strings.ToLower(movie.Title)]))
+		matches = append(matches, match{movie: movie, score: similarity + math.Log(popularity)/20})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].movie.ID < matches[j].movie.ID
+	})
+
+	suggestions := []*MovieSuggestion{}
+	for _, match := range matches[:min(limit, len(matches))] {
+		suggestions = append(suggestions, &MovieSuggestion{
+			ID:    match.movie.ID,
+			Title: match.movie.Title,
+			Year:  match.movie.Year,
+		})
+	}
+
+	return suggestions, nil
+}
+
+// matches reports whether a movie matches the filter, apart from the title and
+// search query, which the in-memory model matches separately.
+func (f MovieFilter) matches(movie *Movie) bool {
+	switch {
+	case !containsAll(movie.Genres, f.Genres):
+		return false
+	case len(f.GenresAny) > 0 && !containsAny(movie.Genres, f.GenresAny):
+		return false
+	case containsAny(movie.Genres, f.GenresExclude):
+		return false
+	case f.Owner != 0 && (movie.CreatedBy == nil || *movie.CreatedBy != f.Owner):
+		return false
+	case f.YearMin != 0 && movie.Year < f.YearMin, f.YearMax != 0 && movie.Year > f.YearMax:
+		return false
+	case f.RuntimeMin != 0 && movie.Runtime < f.RuntimeMin, f.RuntimeMax != 0 && movie.Runtime > f.RuntimeMax:
+		return false
+	case !f.CreatedFrom.IsZero() && movie.CreatedAt.Before(f.CreatedFrom):
+		return false
+	case !f.CreatedUntil.IsZero() && !movie.CreatedAt.Before(f.CreatedUntil):
+		return false
+	}
+
+	return true
+}
+
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	if movie.Genres != nil {
+		c.Genres = append([]string{}, movie.Genres...)
+	}
+	if movie.CreatedBy != nil {
+		createdBy := *movie.CreatedBy
+		c.CreatedBy = &createdBy
+	}
+	return &c
+}
+
+// compareMovies compares two movies by one of the columns in the sort safelist
+// and returns -1, 0 or +1.
+func compareMovies(a, b *Movie, column string) int {
+	switch column {
+	case "id":
+		return compareOrdered(a.ID, b.ID)
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "year":
+		return compareOrdered(a.Year, b.Year)
+	case "runtime":
+		return compareOrdered(a.Runtime, b.Runtime)
+	case "relevance":
+		return compareOrdered(float64(a.relevance), float64(b.relevance))
+	default:
+		panic("unsupported sort column: " + column)
+	}
+}
+
+// movie returns a movie with the cursor's ID and sort column value, which the
+// movies on either side of the cursor can be compared with. Like PostgreSQL, it
+// fails if the value doesn't fit the type of the column.
+func (c *Cursor) movie(column string) (*Movie, error) {
+	movie := &Movie{ID: c.ID}
+
+	switch column {
+	case "title":
+		movie.Title = c.Value
+		return movie, nil
+	case "relevance":
+		relevance, err := strconv.ParseFloat(c.Value, 32)
+		if err != nil {
+			return nil, err
+		}
+		movie.relevance = float32(relevance)
+		return movie, nil
+	}
+
+	n, err := strconv.ParseInt(c.Value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	switch column {
+	case "id":
+		movie.ID = n
+	case "year":
+		movie.Year = int32(n)
+	case "runtime":
+		movie.Runtime = Runtime(n)
+	default:
+		panic("unsupported sort column: " + column)
+	}
+
+	return movie, nil
+}
+
+func compareOrdered[T int64 | int32 | Runtime | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// containsAll reports whether values contains every element of want, which is
+// what the genres @> $2 condition checks.
+func containsAll(values, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, v := range values {
+			if v == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsAny reports whether values contains at least one element of want,
+// which is what the genres && $2 condition checks.
+func containsAny(values, want []string) bool {
+	for _, w := range want {
+		for _, v := range values {
+			if v == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// simpleLexemes splits s into lower-cased words, approximating the lexemes
+// produced by to_tsvector('simple', s).
+func simpleLexemes(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesPlainQuery approximates to_tsvector('simple', text) @@
+// plainto_tsquery('simple', query): every word of the query must appear in
+// the text.
+func matchesPlainQuery(text, query string) bool {
+	words := simpleLexemes(query)
+	if len(words) == 0 {
+		return false
+	}
+
+	lexemes := make(map[string]bool)
+	for _, lexeme := range simpleLexemes(text) {
+		lexemes[lexeme] = true
+	}
+
+	for _, word := range words {
+		if !lexemes[word] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// websearchQuery is a parsed websearch_to_tsquery query: a disjunction of
+// clauses, each of which is a conjunction of terms.
+type websearchQuery [][]websearchTerm
+
+// websearchTerm is a word, or a quoted phrase of consecutive words, which must
+// or, if negated, must not appear in the text.
+type websearchTerm struct {
+	words   []string
+	negated bool
+}
+
+// parseWebsearchQuery approximates websearch_to_tsquery('simple', query).
+// Unquoted words are and-ed together, "or" separates alternatives, quoted
+// text is a phrase, and a leading "-" negates a word or phrase. Like the
+// 'simple' configuration, no stemming or stop words are applied.
+func parseWebsearchQuery(query string) websearchQuery {
+	var (
+		clauses websearchQuery
+		clause  []websearchTerm
+	)
+
+	for len(query) > 0 {
+		query = strings.TrimLeftFunc(query, func(r rune) bool {
+			return r != '"' && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if query == "" {
+			break
+		}
+
+		negated := strings.HasPrefix(query, "-")
+		if negated {
+			query = query[1:]
+		}
+
+		var text string
+
+		quoted := strings.HasPrefix(query, `"`)
+		if quoted {
+			end := strings.IndexByte(query[1:], '"')
+			if end < 0 {
+				text, query = query[1:], ""
+			} else {
+				text, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(query, unicode.IsSpace)
+			if end < 0 {
+				end = len(query)
+			}
+			text, query = query[:end], query[end:]
+		}
+
+		words := simpleLexemes(text)
+		if len(words) == 0 {
+			continue
+		}
+
+		if !negated && len(words) == 1 && words[0] == "or" && !quoted {
+			if len(clause) > 0 {
+				clauses = append(clauses, clause)
+				clause = nil
+			}
+			continue
+		}
+
+		clause = append(clause, websearchTerm{words: words, negated: negated})
+	}
+
+	if len(clause) > 0 {
+		clauses = append(clauses, clause)
+	}
+
+	return clauses
+}
+
+// match reports whether the text matches the query, along with a rank which
+// approximates ts_rank_cd: the number of occurrences of the words of the
+// matching terms, divided by the number of words in the text.
+func (q websearchQuery) match(text string) (float32, bool) {
+	lexemes := simpleLexemes(text)
+	if len(lexemes) == 0 {
+		return 0, false
+	}
+
+	matched := false
+	occurrences := 0
+
+	for _, clause := range q {
+		ok := true
+		count := 0
+
+		for _, term := range clause {
+			n := countPhrase(lexemes, term.words)
+			if (n > 0) == term.negated {
+				ok = false
+				break
+			}
+			if !term.negated {
+				count += n * len(term.words)
+			}
+		}
+
+		if ok {
+			matched = true
+			occurrences += count
+		}
+	}
+
+	return float32(occurrences) / float32(len(lexemes)), matched
+}
+
+// headline approximates ts_headline with headlineOptions: the words of the
+// text which appear in a term of the query that isn't negated are wrapped in
+// the start and stop selectors.
+func (q websearchQuery) headline(text string) string {
+	highlight := make(map[string]bool)
+	for _, clause := range q {
+		for _, term := range clause {
+			for _, word := range term.words {
+				highlight[word] = !term.negated || highlight[word]
+			}
+		}
+	}
+
+	var b strings.Builder
+
+	isWordRune := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+
+	for len(text) > 0 {
+		end := strings.IndexFunc(text, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(text)
+		}
+
+		if end == 0 {
+			next := strings.IndexFunc(text, isWordRune)
+			if next < 0 {
+				next = len(text)
+			}
+			b.WriteString(text[:next])
+			text = text[next:]
+			continue
+		}
+
+		if word := text[:end]; highlight[strings.ToLower(word)] {
+			b.WriteString(headlineStartSel + word + headlineStopSel)
+		} else {
+			b.WriteString(word)
+		}
+
+		text = text[end:]
+	}
+
+	return b.String()
+}
+
+// countPhrase returns the number of times the words appear consecutively in
+// lexemes.
+func countPhrase(lexemes, words []string) int {
+	n := 0
+
+	for i := 0; i+len(words) <= len(lexemes); i++ {
+		if slices.Equal(lexemes[i:i+len(words)], words) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// trigrams returns the set of trigrams of s the way pg_trgm extracts them:
+// each lower-cased word is padded with two spaces in front and one behind.
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+
+	for _, word := range simpleLexemes(s) {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+
+	return set
+}
+
+// wordSimilarity approximates word_similarity(text, title) of pg_trgm: the
+// share of the trigrams of the text which also appear in the title.
+func wordSimilarity(text, title string) float64 {
+	want, have := trigrams(text), trigrams(title)
+	if len(want) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range want {
+		if have[trigram] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(want))
+}
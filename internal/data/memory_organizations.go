@@ -0,0 +1,329 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"time"
+)
+
+type memoryOrganizationModel struct {
+	store *memoryStore
+}
+
+func (m memoryOrganizationModel) Insert(ctx context.Context, org *Organization, adminID int64) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.users[adminID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	m.store.nextOrganizationID++
+
+	org.ID = m.store.nextOrganizationID
+	org.CreatedAt = m.store.now()
+
+	stored := *org
+	m.store.organizations[org.ID] = &stored
+
+	m.store.organizationMembers[org.ID] = map[int64]*Membership{
+		adminID: {
+			OrganizationID: org.ID,
+			UserID:         adminID,
+			Role:           OrganizationRoleAdmin,
+			CreatedAt:      org.CreatedAt,
+		},
+	}
+
+	return nil
+}
+
+func (m memoryOrganizationModel) Get(ctx context.Context, id int64) (*Organization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	org, ok := m.store.organizations[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	c := *org
+	return &c, nil
+}
+
+func (m memoryOrganizationModel) GetMembership(ctx context.Context, orgID, userID int64) (*Membership, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	membership, ok := m.store.organizationMembers[orgID][userID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &Membership{
+		OrganizationID: membership.OrganizationID,
+		UserID:         membership.UserID,
+		Role:           membership.Role,
+		CreatedAt:      membership.CreatedAt,
+	}, nil
+}
+
+func (m memoryOrganizationModel) GetMembers(ctx context.Context, orgID int64) ([]*Membership, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	memberships := []*Membership{}
+
+	for _, membership := range m.store.organizationMembers[orgID] {
+		memberships = append(memberships, m.store.describeMembership(membership))
+	}
+
+	sortMemberships(memberships, func(membership *Membership) int64 {
+		return membership.UserID
+	})
+
+	return memberships, nil
+}
+
+func (m memoryOrganizationModel) GetAllForUser(ctx context.Context, userID int64) ([]*Membership, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	memberships := []*Membership{}
+
+	for _, members := range m.store.organizationMembers {
+		if membership, ok := members[userID]; ok {
+			memberships = append(memberships, m.store.describeMembership(membership))
+		}
+	}
+
+	sortMemberships(memberships, func(membership *Membership) int64 {
+		return membership.OrganizationID
+	})
+
+	return memberships, nil
+}
+
+func (m memoryOrganizationModel) UpdateMember(ctx context.Context, membership *Membership) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	stored, ok := m.store.organizationMembers[membership.OrganizationID][membership.UserID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	if membership.Role != OrganizationRoleAdmin && m.store.lastAdmin(stored) {
+		return ErrLastAdmin
+	}
+
+	stored.Role = membership.Role
+
+	return nil
+}
+
+func (m memoryOrganizationModel) RemoveMember(ctx context.Context, orgID, userID int64) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	stored, ok := m.store.organizationMembers[orgID][userID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	if m.store.lastAdmin(stored) {
+		return ErrLastAdmin
+	}
+
+	m.store.removeMember(orgID, userID)
+
+	return nil
+}
+
+func (m memoryOrganizationModel) GetActive(ctx context.Context, userID int64) (int64, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer m.store.unlock()
+
+	return m.store.activeOrganizations[userID], nil
+}
+
+func (m memoryOrganizationModel) SetActive(ctx context.Context, userID, orgID int64) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if orgID == 0 {
+		delete(m.store.activeOrganizations, userID)
+		return nil
+	}
+
+	// Mirror the foreign key on organization_members.
+	if _, ok := m.store.organizationMembers[orgID][userID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	m.store.activeOrganizations[userID] = orgID
+
+	return nil
+}
+
+// describeMembership returns a copy of the membership with the organization
+// and user details filled in. The caller must hold the store lock.
+func (s *memoryStore) describeMembership(membership *Membership) *Membership {
+	c := *membership
+
+	if org, ok := s.organizations[c.OrganizationID]; ok {
+		c.OrganizationName = org.Name
+	}
+
+	if user, ok := s.users[c.UserID]; ok {
+		c.Name = user.Name
+		c.Email = user.Email
+	}
+
+	return &c
+}
+
+// removeMember deletes a membership along with the member's choice of the
+// organization as their active one, mirroring the ON DELETE CASCADE foreign
+// key. The caller must hold the store lock.
+func (s *memoryStore) removeMember(orgID, userID int64) {
+	delete(s.organizationMembers[orgID], userID)
+
+	if s.activeOrganizations[userID] == orgID {
+		delete(s.activeOrganizations, userID)
+	}
+}
+
+// lastAdmin reports whether the member is the only admin of their organization.
+func (s *memoryStore) lastAdmin(member *Membership) bool {
+	if member.Role != OrganizationRoleAdmin {
+		return false
+	}
+
+	for _, other := range s.organizationMembers[member.OrganizationID] {
+		if other.UserID != member.UserID && other.Role == OrganizationRoleAdmin {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortMemberships orders memberships by the time they were created, and then by
+// the given ID, like the SQL queries do.
+func sortMemberships(memberships []*Membership, id func(*Membership) int64) {
+	sort.Slice(memberships, func(i, j int) bool {
+		a, b := memberships[i], memberships[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return id(a) < id(b)
+	})
+}
+
+type memoryOrganizationInvitationModel struct {
+	store *memoryStore
+}
+
+func (m memoryOrganizationInvitationModel) New(ctx context.Context, orgID int64, email, role string, invitedBy int64,
+	ttl time.Duration) (*OrganizationInvitation, error) {
+
+	token, err := generateToken(invitedBy, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &OrganizationInvitation{
+		Plaintext:      token.Plaintext,
+		Hash:           token.Hash,
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		InvitedBy:      invitedBy,
+		Expiry:         token.Expiry,
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.organizations[orgID]; !ok {
+		return nil, errForeignKeyViolation
+	}
+
+	for hash, existing := range m.store.organizationInvitations {
+		if existing.OrganizationID == orgID && strings.EqualFold(existing.Email, email) {
+			delete(m.store.organizationInvitations, hash)
+		}
+	}
+
+	stored := *invitation
+	stored.Plaintext = ""
+	stored.Expiry = invitation.Expiry.Truncate(time.Second)
+	m.store.organizationInvitations[string(invitation.Hash)] = &stored
+
+	return invitation, nil
+}
+
+func (m memoryOrganizationInvitationModel) Accept(ctx context.Context, tokenPlaintext string,
+	user *User) (*Membership, error) {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	invitation, ok := m.store.organizationInvitations[string(tokenHash[:])]
+	if !ok || !strings.EqualFold(invitation.Email, user.Email) || !invitation.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	delete(m.store.organizationInvitations, string(tokenHash[:]))
+
+	members := m.store.organizationMembers[invitation.OrganizationID]
+	if members == nil {
+		members = make(map[int64]*Membership)
+		m.store.organizationMembers[invitation.OrganizationID] = members
+	}
+
+	membership, ok := members[user.ID]
+	if !ok {
+		membership = &Membership{
+			OrganizationID: invitation.OrganizationID,
+			UserID:         user.ID,
+			Role:           invitation.Role,
+			CreatedAt:      m.store.now(),
+		}
+		members[user.ID] = membership
+	}
+
+	c := *membership
+	return &c, nil
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"greenlight/internal/validator"
+)
+
+type memoryPermissionModel struct {
+	store *memoryStore
+}
+
+func (m memoryPermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	return append(Permissions{}, m.store.permissions...), nil
+}
+
+func (m memoryPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	var permissions Permissions
+
+	for _, code := range m.store.permissions {
+		if m.store.userPermissions[userID][code] || m.store.roleGrants(userID, code) {
+			permissions = append(permissions, code)
+		}
+	}
+
+	return permissions, nil
+}
+
+func (m memoryPermissionModel) GetDirectForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	var permissions Permissions
+
+	for _, code := range m.store.permissions {
+		if m.store.userPermissions[userID][code] {
+			permissions = append(permissions, code)
+		}
+	}
+
+	return permissions, nil
+}
+
+func (m memoryPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.users[userID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	granted := m.store.userPermissions[userID]
+	if granted == nil {
+		granted = make(map[string]bool)
+	}
+
+	// Codes which don't exist are skipped, just like the INSERT ... SELECT
+	// query does.
+	for _, code := range codes {
+		if validator.In(code, m.store.permissions...) {
+			granted[code] = true
+		}
+	}
+
+	m.store.userPermissions[userID] = granted
+
+	return nil
+}
+
+func (m memoryPermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	for _, code := range codes {
+		delete(m.store.userPermissions[userID], code)
+	}
+
+	return nil
+}
+
+// roleGrants reports whether one of the roles assigned to the user includes the
+// permission code. The caller must hold the store lock.
+func (s *memoryStore) roleGrants(userID int64, code string) bool {
+	for roleID := range s.userRoles[userID] {
+		if role, ok := s.roles[roleID]; ok && role.Permissions.Include(code) {
+			return true
+		}
+	}
+
+	return false
+}
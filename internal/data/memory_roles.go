@@ -0,0 +1,225 @@
+package data
+
+import (
+	"context"
+	"greenlight/internal/validator"
+	"sort"
+)
+
+type memoryRoleModel struct {
+	store *memoryStore
+}
+
+func (m memoryRoleModel) Insert(ctx context.Context, role *Role) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if m.store.roleNameTaken(role.Name, 0) {
+		return ErrDuplicateRoleName
+	}
+
+	m.store.nextRoleID++
+
+	role.ID = m.store.nextRoleID
+	role.CreatedAt = m.store.now()
+
+	stored := copyRole(role)
+	stored.Permissions = m.store.knownPermissions(role.Permissions)
+
+	m.store.roles[role.ID] = stored
+
+	return nil
+}
+
+func (m memoryRoleModel) Get(ctx context.Context, id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	role, ok := m.store.roles[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyRole(role), nil
+}
+
+func (m memoryRoleModel) GetByName(ctx context.Context, name string) (*Role, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	for _, role := range m.store.roles {
+		if role.Name == name {
+			return copyRole(role), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryRoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	roles := []*Role{}
+
+	for _, role := range m.store.roles {
+		roles = append(roles, copyRole(role))
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].ID < roles[j].ID
+	})
+
+	return roles, nil
+}
+
+func (m memoryRoleModel) Update(ctx context.Context, role *Role) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.roles[role.ID]; !ok {
+		return ErrRecordNotFound
+	}
+
+	if m.store.roleNameTaken(role.Name, role.ID) {
+		return ErrDuplicateRoleName
+	}
+
+	stored := m.store.roles[role.ID]
+	stored.Name = role.Name
+	stored.Permissions = m.store.knownPermissions(role.Permissions)
+
+	return nil
+}
+
+func (m memoryRoleModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.roles[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.roles, id)
+
+	// Mirror the ON DELETE CASCADE foreign key on user_roles.
+	for _, assigned := range m.store.userRoles {
+		delete(assigned, id)
+	}
+
+	return nil
+}
+
+func (m memoryRoleModel) GetAllForUser(ctx context.Context, userID int64) ([]*Role, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	roles := []*Role{}
+
+	for roleID := range m.store.userRoles[userID] {
+		if role, ok := m.store.roles[roleID]; ok {
+			roles = append(roles, copyRole(role))
+		}
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].ID < roles[j].ID
+	})
+
+	return roles, nil
+}
+
+func (m memoryRoleModel) AddForUser(ctx context.Context, userID, roleID int64) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.users[userID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	if _, ok := m.store.roles[roleID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	assigned := m.store.userRoles[userID]
+	if assigned == nil {
+		assigned = make(map[int64]bool)
+		m.store.userRoles[userID] = assigned
+	}
+
+	assigned[roleID] = true
+
+	return nil
+}
+
+func (m memoryRoleModel) RemoveForUser(ctx context.Context, userID, roleID int64) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if !m.store.userRoles[userID][roleID] {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.userRoles[userID], roleID)
+
+	return nil
+}
+
+// roleNameTaken reports whether a role other than the one with the given ID
+// already has the name. The caller must hold the store lock.
+func (s *memoryStore) roleNameTaken(name string, exceptID int64) bool {
+	for _, role := range s.roles {
+		if role.ID != exceptID && role.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// knownPermissions returns the codes which exist, in the order of the
+// permissions list, just like the SQL model skips unknown codes and returns
+// them ordered by ID. The caller must hold the store lock.
+func (s *memoryStore) knownPermissions(codes Permissions) Permissions {
+	permissions := Permissions{}
+
+	for _, code := range s.permissions {
+		if validator.In(code, codes...) {
+			permissions = append(permissions, code)
+		}
+	}
+
+	return permissions
+}
+
+func copyRole(role *Role) *Role {
+	c := *role
+	c.Permissions = append(Permissions{}, role.Permissions...)
+	return &c
+}
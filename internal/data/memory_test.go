@@ -0,0 +1,391 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+var movieSortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime", "relevance"}
+
+// newTestModels returns empty in-memory models.
+func newTestModels(t *testing.T) Models {
+	t.Helper()
+
+	models, err := NewMemoryModels("simple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return models
+}
+
+func TestNewMemoryModelsSearchConfig(t *testing.T) {
+	for _, config := range []string{"english", "pg_catalog.simple", ""} {
+		_, err := NewMemoryModels(config)
+		if !errors.Is(err, ErrUnsupportedSearchConfig) {
+			t.Errorf("%q: got error %v; want %v", config, err, ErrUnsupportedSearchConfig)
+		}
+	}
+}
+
+// insertTestMovies inserts the movies into the models and returns them with
+// their IDs filled in.
+func insertTestMovies(t *testing.T, models Models, movies ...*Movie) []*Movie {
+	t.Helper()
+
+	for _, movie := range movies {
+		err := models.Movies.Insert(context.Background(), movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return movies
+}
+
+// insertTestUser inserts a user with the given name and email address.
+func insertTestUser(t *testing.T, models Models, name, email string, activated bool) *User {
+	t.Helper()
+
+	user := &User{Name: name, Email: email, Activated: activated}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func movieIDs(movies []*Movie) []int64 {
+	ids := []int64{}
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+	}
+	return ids
+}
+
+func TestMemoryMovieUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(movie *Movie)
+		wantErr error
+	}{
+		{"current version", func(movie *Movie) {}, nil},
+		{"stale version", func(movie *Movie) { movie.Version-- }, ErrEditConflict},
+		{"deleted movie", func(movie *Movie) { movie.ID = 99 }, ErrEditConflict},
+		{"other catalog", func(movie *Movie) { movie.OrganizationID = 7 }, ErrEditConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models := newTestModels(t)
+			ctx := context.Background()
+
+			insertTestMovies(t, models, &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+
+			movie, err := models.Movies.Get(ctx, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.change(movie)
+			movie.Title = "Moana 2"
+			version := movie.Version
+
+			err = models.Movies.Update(ctx, movie)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			stored, err := models.Movies.Get(ctx, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr == nil {
+				if movie.Version != version+1 || stored.Version != movie.Version || stored.Title != "Moana 2" {
+					t.Errorf("got version %d and stored %d %q; want version %d stored", movie.Version,
+						stored.Version, stored.Title, version+1)
+				}
+			} else if stored.Title != "Moana" {
+				t.Errorf("got stored title %q; want it unchanged", stored.Title)
+			}
+		})
+	}
+}
+
+func TestMemoryUserDuplicateEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(models Models) error
+		wantErr error
+	}{
+		{
+			name: "insert same email",
+			write: func(models Models) error {
+				return models.Users.Insert(context.Background(), &User{Name: "B", Email: "alice@example.com"})
+			},
+			wantErr: ErrDuplicateEmail,
+		},
+		{
+			name: "insert email in other case",
+			write: func(models Models) error {
+				return models.Users.Insert(context.Background(), &User{Name: "B", Email: "Alice@Example.com"})
+			},
+			wantErr: ErrDuplicateEmail,
+		},
+		{
+			name: "insert other email",
+			write: func(models Models) error {
+				return models.Users.Insert(context.Background(), &User{Name: "B", Email: "bob@example.com"})
+			},
+		},
+		{
+			name: "update to taken email",
+			write: func(models Models) error {
+				user, err := models.Users.GetByEmail(context.Background(), "carol@example.com")
+				if err != nil {
+					return err
+				}
+				user.Email = "alice@example.com"
+				return models.Users.Update(context.Background(), user)
+			},
+			wantErr: ErrDuplicateEmail,
+		},
+		{
+			name: "update keeping own email",
+			write: func(models Models) error {
+				user, err := models.Users.GetByEmail(context.Background(), "alice@example.com")
+				if err != nil {
+					return err
+				}
+				user.Name = "Alice"
+				return models.Users.Update(context.Background(), user)
+			},
+		},
+		{
+			name: "update stale version",
+			write: func(models Models) error {
+				user, err := models.Users.GetByEmail(context.Background(), "alice@example.com")
+				if err != nil {
+					return err
+				}
+				user.Version--
+				return models.Users.Update(context.Background(), user)
+			},
+			wantErr: ErrEditConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models := newTestModels(t)
+
+			insertTestUser(t, models, "A", "alice@example.com", true)
+			insertTestUser(t, models, "C", "carol@example.com", true)
+
+			err := tt.write(models)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryUserGetForToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		scope     string
+		plaintext func(token *Token) string
+		wantErr   error
+	}{
+		{"valid", time.Hour, ScopeAuthentication, func(token *Token) string { return token.Plaintext }, nil},
+		{"other scope", time.Hour, ScopePasswordReset, func(token *Token) string { return token.Plaintext }, ErrRecordNotFound},
+		{"expired", -time.Second, ScopeAuthentication, func(token *Token) string { return token.Plaintext }, ErrRecordNotFound},
+		{"unknown plaintext", time.Hour, ScopeAuthentication, func(token *Token) string { return "ABCDEFGHIJKLMNOPQRSTUVWXYZ" }, ErrRecordNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models := newTestModels(t)
+			ctx := context.Background()
+
+			user := insertTestUser(t, models, "A", "alice@example.com", true)
+
+			token, err := models.Tokens.New(ctx, user.ID, tt.ttl, ScopeAuthentication)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := models.Users.GetForToken(ctx, tt.scope, tt.plaintext(token))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && got.ID != user.ID {
+				t.Errorf("got user %d; want %d", got.ID, user.ID)
+			}
+		})
+	}
+}
+
+func TestMemoryMovieGetAll(t *testing.T) {
+	models := newTestModels(t)
+	owner := insertTestUser(t, models, "A", "alice@example.com", true).ID
+
+	insertTestMovies(t, models,
+		&Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+		&Movie{Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action", "adventure"}, CreatedBy: &owner},
+		&Movie{Title: "Deadpool", Year: 2016, Runtime: 108, Genres: []string{"action", "comedy"}},
+		&Movie{Title: "The Breakfast Club", Year: 1985, Runtime: 97, Genres: []string{"drama"}},
+		&Movie{Title: "War of the Worlds", Year: 2005, Runtime: 116, Genres: []string{"sci-fi"}},
+		&Movie{Title: "Other catalog", Year: 2016, Runtime: 100, Genres: []string{"drama"}, OrganizationID: 1},
+	)
+
+	tests := []struct {
+		name   string
+		filter MovieFilter
+		sort   string
+		want   []int64
+	}{
+		{"all in catalog", MovieFilter{}, "id", []int64{1, 2, 3, 4, 5}},
+		{"title words", MovieFilter{Title: "black panther"}, "id", []int64{2}},
+		{"title missing word", MovieFilter{Title: "black widow"}, "id", []int64{}},
+		{"all genres", MovieFilter{Genres: []string{"action", "adventure"}}, "id", []int64{2}},
+		{"any genre", MovieFilter{GenresAny: []string{"comedy", "drama"}}, "id", []int64{3, 4}},
+		{"excluded genre", MovieFilter{GenresExclude: []string{"action"}}, "id", []int64{1, 4, 5}},
+		{"owner", MovieFilter{Owner: owner}, "id", []int64{2}},
+		{"year range", MovieFilter{YearMin: 2005, YearMax: 2016}, "id", []int64{1, 3, 5}},
+		{"runtime range", MovieFilter{RuntimeMin: 100, RuntimeMax: 116}, "id", []int64{1, 3, 5}},
+		{"search or", MovieFilter{Search: "moana or deadpool"}, "id", []int64{1, 3}},
+		{"search negated", MovieFilter{Search: "the -club"}, "id", []int64{5}},
+		{"search phrase", MovieFilter{Search: `"breakfast club"`}, "id", []int64{4}},
+
+		// Titles are matched like the 'simple' configuration: words are
+		// lower-cased, but neither stemmed nor dropped as stop words.
+		{"search without stemming", MovieFilter{Search: "wars"}, "id", []int64{}},
+		{"search stop word", MovieFilter{Search: "THE"}, "id", []int64{4, 5}},
+
+		{"sort by title", MovieFilter{}, "title", []int64{2, 3, 1, 4, 5}},
+		{"sort by year descending, then id", MovieFilter{}, "-year", []int64{2, 1, 3, 5, 4}},
+		{"sort by runtime", MovieFilter{}, "runtime", []int64{4, 1, 3, 5, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafeList: movieSortSafeList, IncludeTotal: true}
+
+			movies, metadata, err := models.Movies.GetAll(context.Background(), 0, tt.filter, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := movieIDs(movies); !slices.Equal(got, tt.want) {
+				t.Errorf("got movies %v; want %v", got, tt.want)
+			}
+
+			if metadata.TotalRecords != len(tt.want) {
+				t.Errorf("got total %d; want %d", metadata.TotalRecords, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestMemoryMovieGetAllPages(t *testing.T) {
+	models := newTestModels(t)
+
+	for _, title := range []string{"E", "D", "C", "B", "A"} {
+		insertTestMovies(t, models, &Movie{Title: title, Year: 2000, Runtime: 90, Genres: []string{"drama"}})
+	}
+
+	tests := []struct {
+		page int
+		want []int64
+	}{
+		{1, []int64{5, 4}},
+		{2, []int64{3, 2}},
+		{3, []int64{1}},
+		{4, []int64{}},
+	}
+
+	for _, tt := range tests {
+		filters := Filters{Page: tt.page, PageSize: 2, Sort: "title", SortSafeList: movieSortSafeList, IncludeTotal: true}
+
+		movies, metadata, err := models.Movies.GetAll(context.Background(), 0, MovieFilter{}, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := movieIDs(movies); !slices.Equal(got, tt.want) {
+			t.Errorf("page %d: got movies %v; want %v", tt.page, got, tt.want)
+		}
+
+		// Like the SQL model, an empty page carries no metadata.
+		wantTotal, wantLast := 5, 3
+		if len(tt.want) == 0 {
+			wantTotal, wantLast = 0, 0
+		}
+
+		if metadata.TotalRecords != wantTotal || metadata.LastPage != wantLast {
+			t.Errorf("page %d: got total %d and last page %d; want %d and %d", tt.page,
+				metadata.TotalRecords, metadata.LastPage, wantTotal, wantLast)
+		}
+	}
+}
+
+func TestMemoryUserGetAll(t *testing.T) {
+	models := newTestModels(t)
+
+	insertTestUser(t, models, "Carol", "carol@example.com", true)
+	insertTestUser(t, models, "Alice", "Alice@example.org", false)
+	insertTestUser(t, models, "Bob", "bob@example.com", true)
+
+	activated, deactivated := true, false
+
+	tests := []struct {
+		name      string
+		search    string
+		activated *bool
+		sort      string
+		want      []int64
+	}{
+		{"all", "", nil, "id", []int64{1, 2, 3}},
+		{"search email, any case", "EXAMPLE.COM", nil, "id", []int64{1, 3}},
+		{"search name", "bo", nil, "id", []int64{3}},
+		{"activated", "", &activated, "id", []int64{1, 3}},
+		{"not activated", "", &deactivated, "id", []int64{2}},
+		{"sort by email ignoring case", "", nil, "email", []int64{2, 3, 1}},
+		{"sort by name descending", "", nil, "-name", []int64{1, 3, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := Filters{Page: 1, PageSize: 20, Sort: tt.sort,
+				SortSafeList: []string{"id", "name", "email", "-id", "-name", "-email"}}
+
+			users, _, err := models.Users.GetAll(context.Background(), tt.search, tt.activated, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []int64{}
+			for _, user := range users {
+				got = append(got, user.ID)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got users %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
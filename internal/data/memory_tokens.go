@@ -0,0 +1,313 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"sort"
+	"time"
+)
+
+type memoryTokenModel struct {
+	store *memoryStore
+}
+
+func (m memoryTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokenModel) NewSession(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration,
+	ip, userAgent string) (*Token, *Token, error) {
+
+	access, refresh, err := generateTokenPair(userID, "", accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer m.store.unlock()
+
+	for _, token := range pairTokens(access, refresh) {
+		err = m.store.insertToken(token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+func (m memoryTokenModel) Rotate(ctx context.Context, refreshTokenPlaintext string, accessTTL, refreshTTL time.Duration,
+	ip, userAgent string) (*Token, *Token, error) {
+
+	tokenHash := sha256.Sum256([]byte(refreshTokenPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer m.store.unlock()
+
+	now := m.store.now()
+
+	old, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || old.Scope != ScopeRefresh || !old.Expiry.After(now) {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	if old.UsedAt != nil {
+		m.store.deleteTokens(func(token *Token) bool {
+			return token.Family == old.Family
+		})
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	old.UsedAt = &now
+
+	m.store.deleteTokens(func(token *Token) bool {
+		return token.Family == old.Family && token.Scope == ScopeAuthentication
+	})
+
+	access, refresh, err := generateTokenPair(old.UserID, old.Family, accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range pairTokens(access, refresh) {
+		err = m.store.insertToken(token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+func (m memoryTokenModel) Insert(ctx context.Context, token *Token) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	return m.store.insertToken(token)
+}
+
+func (m memoryTokenModel) Consume(ctx context.Context, scope, tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer m.store.unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != scope || !token.Expiry.After(m.store.now()) {
+		return 0, ErrRecordNotFound
+	}
+
+	m.store.deleteTokens(func(t *Token) bool {
+		return t == token
+	})
+
+	return token.UserID, nil
+}
+
+func (m memoryTokenModel) DeleteFamily(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != scope {
+		return ErrRecordNotFound
+	}
+
+	m.store.deleteTokens(func(t *Token) bool {
+		return t.Family == token.Family
+	})
+
+	return nil
+}
+
+func (m memoryTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	m.store.deleteTokens(func(token *Token) bool {
+		return token.Scope == scope && token.UserID == userID
+	})
+
+	return nil
+}
+
+func (m memoryTokenModel) GetSessionsForUser(ctx context.Context, userID int64, currentTokenPlaintext string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentTokenPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	type family struct {
+		session       Session
+		lastCreatedAt time.Time
+		active        bool
+	}
+
+	families := make(map[string]*family)
+
+	now := m.store.now()
+	for _, token := range m.store.tokens {
+		if token.UserID != userID || !token.Expiry.After(now) ||
+			(token.Scope != ScopeAuthentication && token.Scope != ScopeRefresh) {
+			continue
+		}
+
+		f, ok := families[token.Family]
+		if !ok {
+			f = &family{session: Session{ID: token.Family, CreatedAt: token.CreatedAt}}
+			families[token.Family] = f
+		}
+
+		if token.CreatedAt.Before(f.session.CreatedAt) {
+			f.session.CreatedAt = token.CreatedAt
+		}
+
+		if token.LastUsedAt != nil && (f.session.LastUsedAt == nil || token.LastUsedAt.After(*f.session.LastUsedAt)) {
+			f.session.LastUsedAt = token.LastUsedAt
+		}
+
+		if token.UsedAt == nil {
+			f.active = true
+			if token.Expiry.After(f.session.Expiry) {
+				f.session.Expiry = token.Expiry
+			}
+		}
+
+		if !token.CreatedAt.Before(f.lastCreatedAt) {
+			f.lastCreatedAt = token.CreatedAt
+			f.session.IP = token.IP
+			f.session.UserAgent = token.UserAgent
+		}
+
+		if string(token.Hash) == string(currentHash[:]) {
+			f.session.Current = true
+		}
+	}
+
+	sessions := []*Session{}
+	for _, f := range families {
+		if f.active {
+			session := f.session
+			sessions = append(sessions, &session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (m memoryTokenModel) DeleteSession(ctx context.Context, userID int64, id string) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	deleted := m.store.deleteTokens(func(token *Token) bool {
+		return token.Family == id && token.UserID == userID &&
+			(token.Scope == ScopeAuthentication || token.Scope == ScopeRefresh)
+	})
+
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m memoryTokenModel) DeleteOtherSessions(ctx context.Context, userID int64, keepID string) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	m.store.deleteTokens(func(token *Token) bool {
+		return token.UserID == userID && token.Family != keepID &&
+			(token.Scope == ScopeAuthentication || token.Scope == ScopeRefresh)
+	})
+
+	return nil
+}
+
+func (m memoryTokenModel) Touch(ctx context.Context, tokenPlaintext string, interval time.Duration) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok {
+		return nil
+	}
+
+	now := m.store.now()
+	if token.LastUsedAt == nil || token.LastUsedAt.Before(now.Add(-interval)) {
+		token.LastUsedAt = &now
+	}
+
+	return nil
+}
+
+// insertToken stores a copy of the token, without its plaintext. It must be
+// called with the store lock held.
+func (s *memoryStore) insertToken(token *Token) error {
+	// Mirror the foreign key on tokens.user_id.
+	if _, ok := s.users[token.UserID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	token.CreatedAt = s.now()
+
+	c := *token
+	c.Plaintext = ""
+	c.Expiry = token.Expiry.Truncate(time.Second)
+	s.tokens[string(token.Hash)] = &c
+
+	return nil
+}
+
+// deleteTokens deletes every token for which match returns true and returns the
+// number of tokens deleted, along with any pending email change held by them. It
+// must be called with the store lock held.
+func (s *memoryStore) deleteTokens(match func(token *Token) bool) int {
+	deleted := 0
+
+	for key, token := range s.tokens {
+		if match(token) {
+			delete(s.tokens, key)
+			delete(s.emailChanges, key)
+			deleted++
+		}
+	}
+
+	return deleted
+}
@@ -0,0 +1,320 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"greenlight/internal/validator"
+	"sort"
+	"strings"
+)
+
+type memoryUserModel struct {
+	store *memoryStore
+}
+
+func (m memoryUserModel) Insert(ctx context.Context, user *User) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if m.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	m.store.nextUserID++
+
+	user.ID = m.store.nextUserID
+	user.CreatedAt = m.store.now()
+	user.Version = 1
+
+	m.store.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (m memoryUserModel) Register(ctx context.Context, user *User, roleID int64, invitationCode string) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	// Check everything before changing anything, so that a failure leaves the
+	// store as it was, like the rolled back transaction of the SQL model.
+	var code *InvitationCode
+
+	if invitationCode != "" {
+		code = m.store.redeemableInvitationCode(invitationCode, user.Email)
+		if code == nil {
+			return ErrInvalidInvitationCode
+		}
+	}
+
+	if m.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	if _, ok := m.store.roles[roleID]; roleID != 0 && !ok {
+		return errForeignKeyViolation
+	}
+
+	m.store.nextUserID++
+
+	user.ID = m.store.nextUserID
+	user.CreatedAt = m.store.now()
+	user.Version = 1
+
+	m.store.users[user.ID] = copyUser(user)
+
+	if roleID != 0 {
+		m.store.userRoles[user.ID] = map[int64]bool{roleID: true}
+	}
+
+	if code == nil {
+		return nil
+	}
+
+	code.Uses++
+
+	granted := make(map[string]bool)
+	for _, permission := range code.Permissions {
+		if validator.In(permission, m.store.permissions...) {
+			granted[permission] = true
+		}
+	}
+
+	if len(granted) > 0 {
+		m.store.userPermissions[user.ID] = granted
+	}
+
+	return nil
+}
+
+func (m memoryUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	user, ok := m.store.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (m memoryUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	for _, user := range m.store.users {
+		// The email column is citext, so lookups are case-insensitive.
+		if strings.EqualFold(user.Email, email) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryUserModel) Update(ctx context.Context, user *User) error {
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if m.store.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	existing, ok := m.store.users[user.ID]
+	if !ok || existing.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	user.Version++
+
+	updated := copyUser(user)
+	updated.CreatedAt = existing.CreatedAt
+	m.store.users[user.ID] = updated
+
+	return nil
+}
+
+func (m memoryUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(m.store.now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := m.store.users[token.UserID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (m memoryUserModel) GetAll(ctx context.Context, search string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	if err := m.store.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer m.store.unlock()
+
+	search = strings.ToLower(search)
+
+	matches := []*User{}
+
+	for _, user := range m.store.users {
+		if search != "" && !strings.Contains(strings.ToLower(user.Email), search) &&
+			!strings.Contains(strings.ToLower(user.Name), search) {
+			continue
+		}
+
+		if activated != nil && user.Activated != *activated {
+			continue
+		}
+
+		matches = append(matches, user)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		c := compareUsers(matches[i], matches[j], column)
+		if direction == "DESC" {
+			c = -c
+		}
+
+		if c != 0 {
+			return c < 0
+		}
+
+		return matches[i].ID < matches[j].ID
+	})
+
+	totalRecords := len(matches)
+
+	start := min(filters.offset(), totalRecords)
+	end := min(start+filters.limit(), totalRecords)
+
+	users := []*User{}
+	for _, user := range matches[start:end] {
+		users = append(users, copyUser(user))
+	}
+
+	if len(users) == 0 {
+		totalRecords = 0
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+func (m memoryUserModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	if err := m.store.lock(ctx); err != nil {
+		return err
+	}
+	defer m.store.unlock()
+
+	if _, ok := m.store.users[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.users, id)
+
+	// Mirror the ON DELETE CASCADE foreign keys on the user's other records.
+	m.store.deleteTokens(func(token *Token) bool {
+		return token.UserID == id
+	})
+
+	for keyID, key := range m.store.apiKeys {
+		if key.UserID == id {
+			delete(m.store.apiKeys, keyID)
+		}
+	}
+
+	delete(m.store.userPermissions, id)
+	delete(m.store.userRoles, id)
+
+	for orgID := range m.store.organizationMembers {
+		m.store.removeMember(orgID, id)
+	}
+
+	for _, invitation := range m.store.organizationInvitations {
+		if invitation.InvitedBy == id {
+			invitation.InvitedBy = 0
+		}
+	}
+
+	for _, code := range m.store.invitationCodes {
+		if code.CreatedBy != nil && *code.CreatedBy == id {
+			code.CreatedBy = nil
+		}
+	}
+
+	// Mirror the ON DELETE SET NULL foreign key on movies.created_by.
+	for _, movie := range m.store.movies {
+		if movie.CreatedBy != nil && *movie.CreatedBy == id {
+			movie.CreatedBy = nil
+		}
+	}
+	delete(m.store.totp, id)
+	m.store.deleteRecoveryCodes(id)
+
+	return nil
+}
+
+// emailTaken reports whether a user other than exceptID already has the given
+// email address. It must be called with the store lock held.
+func (s *memoryStore) emailTaken(email string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyUser returns a copy of the user which doesn't carry the plaintext
+// password, as a user read back from the database wouldn't.
+func copyUser(user *User) *User {
+	c := *user
+	c.Password = password{hash: append([]byte{}, user.Password.hash...)}
+	return &c
+}
+
+func compareUsers(a, b *User, column string) int {
+	switch column {
+	case "id":
+		return compareOrdered(a.ID, b.ID)
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "email":
+		return strings.Compare(strings.ToLower(a.Email), strings.ToLower(b.Email))
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	default:
+		panic("unsupported sort column: " + column)
+	}
+}
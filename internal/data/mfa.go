@@ -0,0 +1,290 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// recoveryCodeCount is the number of recovery codes issued when two-factor
+// authentication is enabled.
+const recoveryCodeCount = 10
+
+var (
+	// ErrTOTPEnabled is returned when starting a TOTP enrollment for a user who
+	// has already confirmed one.
+	ErrTOTPEnabled = errors.New("totp already enabled")
+
+	// ErrTOTPCodeReused is returned when a TOTP code is presented for a time step
+	// at or before the last one used, so that each code only works once.
+	ErrTOTPCodeReused = errors.New("totp code reused")
+)
+
+// TOTP is the time-based one-time password enrollment of a user. Secret holds
+// the shared secret encrypted by the caller; the data package never sees it in
+// the clear.
+type TOTP struct {
+	UserID       int64
+	Secret       []byte
+	Confirmed    bool
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// generateRecoveryCodes returns a new set of recovery codes along with their
+// hashes. Like tokens, only the SHA-256 hash of each code is ever stored.
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The codes are printed as two groups of four characters, such as
+		// "7q2m-xk4d", which makes them easier to copy down.
+		code := base32.StdEncoding.EncodeToString(randomBytes)
+		codes[i] = strings.ToLower(code[:4] + "-" + code[4:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// MFARepository is the set of operations the application performs on the
+// second factors of users.
+type MFARepository interface {
+	GetTOTP(ctx context.Context, userID int64) (*TOTP, error)
+	SetTOTP(ctx context.Context, userID int64, secret []byte) error
+	ConfirmTOTP(ctx context.Context, userID int64, step int64) ([]string, error)
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int64, code string) error
+	DeleteTOTP(ctx context.Context, userID int64) error
+}
+
+type MFAModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+func (m MFAModel) GetTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, secret, confirmed, last_used_step, created_at
+		FROM user_totp
+		WHERE user_id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Confirmed,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// SetTOTP starts a TOTP enrollment with the given (encrypted) secret, replacing
+// any enrollment which hasn't been confirmed yet. It returns ErrTOTPEnabled if
+// the user has already confirmed one.
+func (m MFAModel) SetTOTP(ctx context.Context, userID int64, secret []byte) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_totp.confirmed = false`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPEnabled
+	}
+
+	return nil
+}
+
+// ConfirmTOTP enables a pending TOTP enrollment, recording the time step of the
+// code used to confirm it, and replaces the user's recovery codes with a new
+// set. The plaintext recovery codes are returned; they can't be retrieved
+// again. It returns ErrRecordNotFound if there is no pending enrollment.
+func (m MFAModel) ConfirmTOTP(ctx context.Context, userID int64, step int64) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_totp
+		SET confirmed = true, last_used_step = $2
+		WHERE user_id = $1 AND confirmed = false`
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO recovery_codes (hash, user_id)
+		SELECT unnest($1::bytea[]), $2`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(hashes), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseTOTPStep records that a code for the given time step has been used. It
+// returns ErrTOTPCodeReused if a code for the same or a later step has already
+// been used, including by a concurrent request.
+func (m MFAModel) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed = true AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPCodeReused
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes. It returns
+// ErrRecordNotFound if the code doesn't exist or has already been used.
+func (m MFAModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+		DELETE FROM recovery_codes
+		WHERE hash = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteTOTP disables two-factor authentication for the user, deleting the TOTP
+// enrollment and any remaining recovery codes.
+func (m MFAModel) DeleteTOTP(ctx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
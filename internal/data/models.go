@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -10,18 +11,40 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// Models groups the repositories used by the application. The handlers only
+// depend on the interfaces, so the PostgreSQL models returned by NewModels can
+// be swapped for the in-memory ones returned by NewMemoryModels.
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	APIKeys                 APIKeyRepository
+	EmailChanges            EmailChangeRepository
+	InvitationCodes         InvitationCodeRepository
+	LoginAttempts           LoginAttemptRepository
+	MFA                     MFARepository
+	Movies                  MovieRepository
+	Organizations           OrganizationRepository
+	OrganizationInvitations OrganizationInvitationRepository
+	Permissions             PermissionRepository
+	Roles                   RoleRepository
+	Tokens                  TokenRepository
+	Users                   UserRepository
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns a Models struct backed by the given connection pool. Every
+// query is run with the caller's context, bounded by queryTimeout. Movie titles
+// are searched with the searchConfig text search configuration.
+func NewModels(db *sql.DB, queryTimeout time.Duration, searchConfig string) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		APIKeys:                 APIKeyModel{DB: db, QueryTimeout: queryTimeout},
+		EmailChanges:            EmailChangeModel{DB: db, QueryTimeout: queryTimeout},
+		InvitationCodes:         InvitationCodeModel{DB: db, QueryTimeout: queryTimeout},
+		LoginAttempts:           LoginAttemptModel{DB: db, QueryTimeout: queryTimeout},
+		MFA:                     MFAModel{DB: db, QueryTimeout: queryTimeout},
+		Movies:                  MovieModel{DB: db, QueryTimeout: queryTimeout, SearchConfig: searchConfig},
+		Organizations:           OrganizationModel{DB: db, QueryTimeout: queryTimeout},
+		OrganizationInvitations: OrganizationInvitationModel{DB: db, QueryTimeout: queryTimeout},
+		Permissions:             PermissionModel{DB: db, QueryTimeout: queryTimeout},
+		Roles:                   RoleModel{DB: db, QueryTimeout: queryTimeout},
+		Users:                   UserModel{DB: db, QueryTimeout: queryTimeout},
+		Tokens:                  TokenModel{DB: db, QueryTimeout: queryTimeout},
 	}
 }
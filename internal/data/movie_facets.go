@@ -0,0 +1,74 @@
+package data
+
+// runtimeFacetBounds are the runtimes, in minutes, at which the runtime
+// buckets of the facets start, after the first bucket of shorter movies.
+var runtimeFacetBounds = []int32{90, 120, 150, 180}
+
+// MovieFacets counts the movies of a listing per genre, release decade and
+// runtime bucket. Only the values with at least one movie are included.
+type MovieFacets struct {
+	Genres   []GenreFacet   `json:"genres"`
+	Decades  []DecadeFacet  `json:"decades"`
+	Runtimes []RuntimeFacet `json:"runtimes"`
+}
+
+type GenreFacet struct {
+	Genre string `json:"genre"`
+	Count int    `json:"count"`
+}
+
+// DecadeFacet counts the movies released from Decade to Decade+9.
+type DecadeFacet struct {
+	Decade int32 `json:"decade"`
+	Count  int   `json:"count"`
+}
+
+// RuntimeFacet counts the movies with a runtime from Min to Max minutes, both
+// inclusive. The last bucket has no maximum.
+type RuntimeFacet struct {
+	Min   int32 `json:"min"`
+	Max   int32 `json:"max,omitempty"`
+	Count int   `json:"count"`
+}
+
+// runtimeBucket returns the bucket of a runtime like PostgreSQL's width_bucket
+// function does with the bounds: 0 below the first bound, and otherwise the
+// number of bounds it is at or above.
+func runtimeBucket(runtime Runtime) int {
+	bucket := 0
+	for _, bound := range runtimeFacetBounds {
+		if int32(runtime) >= bound {
+			bucket++
+		}
+	}
+
+	return bucket
+}
+
+func newRuntimeFacet(bucket, count int) RuntimeFacet {
+	facet := RuntimeFacet{Min: 1, Count: count}
+
+	if bucket > 0 {
+		facet.Min = runtimeFacetBounds[bucket-1]
+	}
+
+	if bucket < len(runtimeFacetBounds) {
+		facet.Max = runtimeFacetBounds[bucket] - 1
+	}
+
+	return facet
+}
+
+// facetFilters returns the filters to count the decades and runtimes with.
+// Each leaves out its own bounds, so that the counts tell how many movies
+// there would be with the bounds changed to another decade or bucket. The
+// genres are counted with the filter as it is, which tells how many movies
+// there would be with one more genre required.
+func (f MovieFilter) facetFilters() (decades, runtimes MovieFilter) {
+	decades, runtimes = f, f
+
+	decades.YearMin, decades.YearMax = 0, 0
+	runtimes.RuntimeMin, runtimes.RuntimeMax = 0, 0
+
+	return decades, runtimes
+}
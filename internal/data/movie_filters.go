@@ -0,0 +1,199 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"greenlight/internal/validator"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieFilter selects the movies of a listing. Zero values don't filter: an
+// empty text or list, a zero owner, bound or time.
+type MovieFilter struct {
+	// Title matches titles containing every word, and Search is a query in
+	// web search syntax.
+	Title  string
+	Search string
+
+	// Genres must all be among the genres of a movie, at least one of
+	// GenresAny must be, and none of GenresExclude may be.
+	Genres        []string
+	GenresAny     []string
+	GenresExclude []string
+
+	// Owner is the ID of the user who created the movies.
+	Owner int64
+
+	// YearMin, YearMax, RuntimeMin and RuntimeMax are inclusive bounds.
+	YearMin    int32
+	YearMax    int32
+	RuntimeMin Runtime
+	RuntimeMax Runtime
+
+	// CreatedFrom is inclusive and CreatedUntil exclusive.
+	CreatedFrom  time.Time
+	CreatedUntil time.Time
+}
+
+func ValidateMovieFilter(v *validator.Validator, f MovieFilter) {
+	v.Check(len(f.Search) <= 500, "q", "must not be more than 500 bytes long")
+
+	for key, genres := range map[string][]string{
+		"genres":         f.Genres,
+		"genres_any":     f.GenresAny,
+		"genres_exclude": f.GenresExclude,
+	} {
+		v.Check(len(genres) <= 20, key, "must not contain more than 20 genres")
+		v.Check(validator.Unique(genres), key, "must not contain duplicate values")
+	}
+
+	if f.YearMin != 0 {
+		v.Check(f.YearMin >= 1888, "year_min", "must be greater than 1888")
+	}
+	if f.YearMax != 0 {
+		v.Check(f.YearMax >= 1888, "year_max", "must be greater than 1888")
+	}
+	if f.YearMin != 0 && f.YearMax != 0 {
+		v.Check(f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	if f.RuntimeMin != 0 {
+		v.Check(f.RuntimeMin > 0, "runtime_min", "must be a positive integer")
+	}
+	if f.RuntimeMax != 0 {
+		v.Check(f.RuntimeMax > 0, "runtime_max", "must be a positive integer")
+	}
+	if f.RuntimeMin != 0 && f.RuntimeMax != 0 {
+		v.Check(f.RuntimeMin <= f.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+
+	if !f.CreatedFrom.IsZero() && !f.CreatedUntil.IsZero() {
+		v.Check(f.CreatedFrom.Before(f.CreatedUntil), "created_at_min", "must be before created_at_max")
+	}
+}
+
+// CursorScope returns a hash of the filter and the ID of the catalog it is
+// applied to, for binding cursors to the listing they were made for. The
+// genre lists are sorted first, as their order doesn't change the listing.
+func (f MovieFilter) CursorScope(orgID int64) string {
+	f.Genres = slices.Sorted(slices.Values(f.Genres))
+	f.GenresAny = slices.Sorted(slices.Values(f.GenresAny))
+	f.GenresExclude = slices.Sorted(slices.Values(f.GenresExclude))
+	f.CreatedFrom = f.CreatedFrom.UTC()
+	f.CreatedUntil = f.CreatedUntil.UTC()
+
+	js, err := json.Marshal(struct {
+		OrganizationID int64
+		Filter         MovieFilter
+	}{orgID, f})
+	if err != nil {
+		panic(err)
+	}
+
+	hash := sha256.Sum256(js)
+
+	return base64.RawURLEncoding.EncodeToString(hash[:16])
+}
+
+// whereClause builds the WHERE clause of a query. The values compared against
+// are only ever passed as parameters; the conditions themselves are made up of
+// column names and placeholders.
+type whereClause struct {
+	conditions []string
+	args       []interface{}
+}
+
+// param adds a parameter and returns its placeholder.
+func (w *whereClause) param(value interface{}) string {
+	w.args = append(w.args, value)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// and adds a condition, formatted with the placeholders of the values.
+func (w *whereClause) and(format string, values ...interface{}) {
+	placeholders := make([]interface{}, len(values))
+	for i, value := range values {
+		placeholders[i] = w.param(value)
+	}
+
+	w.conditions = append(w.conditions, fmt.Sprintf(format, placeholders...))
+}
+
+// add adds a condition whose placeholders were taken with param.
+func (w *whereClause) add(condition string) {
+	w.conditions = append(w.conditions, condition)
+}
+
+func (w *whereClause) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+
+	return "\n\tWHERE " + strings.Join(w.conditions, "\n\tAND ")
+}
+
+// where returns the WHERE clause selecting the movies of a catalog which match
+// the filter. The title and search query are matched with the given (quoted)
+// text search configuration, unless withText is false.
+func (f MovieFilter) where(orgID int64, config string, withText bool) *whereClause {
+	w := &whereClause{}
+
+	w.and("COALESCE(organization_id, 0) = %s", orgID)
+
+	if withText && f.Title != "" {
+		w.and(fmt.Sprintf("to_tsvector(%[1]s, title) @@ plainto_tsquery(%[1]s, %%s)", config), f.Title)
+	}
+
+	if withText && f.Search != "" {
+		w.and(fmt.Sprintf("to_tsvector(%[1]s, title) @@ websearch_to_tsquery(%[1]s, %%s)", config), f.Search)
+	}
+
+	if len(f.Genres) > 0 {
+		w.and("genres @> %s", pq.Array(f.Genres))
+	}
+
+	if len(f.GenresAny) > 0 {
+		w.and("genres && %s", pq.Array(f.GenresAny))
+	}
+
+	if len(f.GenresExclude) > 0 {
+		w.and("NOT genres && %s", pq.Array(f.GenresExclude))
+	}
+
+	if f.Owner != 0 {
+		w.and("created_by = %s", f.Owner)
+	}
+
+	if f.YearMin != 0 {
+		w.and("year >= %s", f.YearMin)
+	}
+
+	if f.YearMax != 0 {
+		w.and("year <= %s", f.YearMax)
+	}
+
+	if f.RuntimeMin != 0 {
+		w.and("runtime >= %s", int32(f.RuntimeMin))
+	}
+
+	if f.RuntimeMax != 0 {
+		w.and("runtime <= %s", int32(f.RuntimeMax))
+	}
+
+	if !f.CreatedFrom.IsZero() {
+		w.and("created_at >= %s", f.CreatedFrom)
+	}
+
+	if !f.CreatedUntil.IsZero() {
+		w.and("created_at < %s", f.CreatedUntil)
+	}
+
+	return w
+}
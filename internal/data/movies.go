@@ -6,56 +6,120 @@ import (
 	"errors"
 	"fmt"
 	"greenlight/internal/validator"
+	"html"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty,string"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"-"`
+	Title          string    `json:"title"`
+	Year           int32     `json:"year,omitempty"`
+	Runtime        Runtime   `json:"runtime,omitempty,string"`
+	Genres         []string  `json:"genres,omitempty"`
+	CreatedBy      *int64    `json:"created_by"`
+	OrganizationID int64     `json:"organization_id,omitempty"`
+	Version        int32     `json:"version"`
+
+	// Headline is the title as HTML, with the words matching a search query
+	// wrapped in <b> tags and everything else escaped. It is only set by
+	// GetAll when given a search query.
+	Headline string `json:"headline,omitempty"`
+
+	// relevance is the negated rank of the movie against a search query, so
+	// that sorting by it in ascending order puts the best matches first.
+	relevance float32
 }
 
+// MovieSuggestion is a movie suggested for a partially typed title.
+type MovieSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+// CanModify reports whether the user may change or delete the movie. Users can
+// only change the movies they created, unless they hold the movies:admin
+// permission. Movies whose creator is unknown, because they predate ownership
+// or their creator was deleted, can only be changed by movie admins.
+func (movie *Movie) CanModify(userID int64, permissions Permissions) bool {
+	if permissions.Include("movies:admin") {
+		return true
+	}
+
+	return movie.CreatedBy != nil && *movie.CreatedBy == userID
+}
+
+// MovieRepository is the set of operations the application performs on movies.
+// MovieModel implements it on top of PostgreSQL and memoryMovieModel in memory.
+//
+// Every movie belongs to the catalog of an organization, or to the shared
+// catalog when its OrganizationID is 0. Each method only ever sees the movies
+// of a single catalog: the one given by orgID, or by the movie's
+// OrganizationID for Insert and Update.
+type MovieRepository interface {
+	Insert(ctx context.Context, movie *Movie) error
+	Get(ctx context.Context, orgID, id int64) (*Movie, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, orgID, id int64) error
+	GetAll(ctx context.Context, orgID int64, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error)
+	GetSimilar(ctx context.Context, orgID int64, text string, filter MovieFilter, limit int) ([]*Movie, error)
+	GetAllWithFacets(ctx context.Context, orgID int64, filter MovieFilter, filters Filters) ([]*Movie, Metadata,
+		*MovieFacets, error)
+	Suggest(ctx context.Context, orgID int64, prefix string, limit int) ([]*MovieSuggestion, error)
+}
+
+// MovieModel searches titles with the SearchConfig text search configuration,
+// which has to match the one movies_title_idx is built with for the index to be
+// used.
 type MovieModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	SearchConfig string
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
-	query := `INSERT INTO movies (title, year, runtime, genres) 
-	VALUES ($1, $2, $3, $4) RETURNING id, created_at, version
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
+	query := `INSERT INTO movies (title, year, runtime, genres, created_by, organization_id) 
+	VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0)) RETURNING id, created_at, version
 	`
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy,
+		movie.OrganizationID}
 
-	return m.DB.QueryRow(query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, orgID, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `SELECT id, created_at, title, year, runtime, genres, version
+	query := `SELECT id, created_at, title, year, runtime, genres, created_by,
+	COALESCE(organization_id, 0), version
 	FROM movies
-	WHERE id = $1`
+	WHERE id = $1 AND COALESCE(organization_id, 0) = $2`
 
 	var movie Movie
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, orgID).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		&movie.CreatedBy,
+		&movie.OrganizationID,
 		&movie.Version,
 	)
 
@@ -71,11 +135,12 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 	query := `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
 		WHERE id = $5 AND version = $6
+		AND COALESCE(organization_id, 0) = $7
 		RETURNING version`
 
 	args := []interface{}{
@@ -85,9 +150,10 @@ func (m MovieModel) Update(movie *Movie) error {
 		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version,
+		movie.OrganizationID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
@@ -103,19 +169,19 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, orgID, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
 		DELETE FROM movies
-		WHERE id = $1`
+		WHERE id = $1 AND COALESCE(organization_id, 0) = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, orgID)
 	if err != nil {
 		return err
 	}
@@ -132,21 +198,131 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	query := fmt.Sprintf(`
-	SELECT count(*) OVER(),  id, created_at, title, year, runtime, genres, version
-	FROM movies
-	WHERE (to_tsvector('simple',title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
-	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+// The headlines of search results are made with the highlighted words between
+// control characters rather than tags, as a title could contain markup of its
+// own. highlightHTML then escapes the headline and turns them into <b> tags.
+const (
+	headlineStartSel = "\x02"
+	headlineStopSel  = "\x03"
+	headlineOptions  = `StartSel="` + headlineStartSel + `", StopSel="` + headlineStopSel + `", HighlightAll=true`
+)
+
+var headlineReplacer = strings.NewReplacer(headlineStartSel, "<b>", headlineStopSel, "</b>")
+
+// highlightHTML turns a headline made with headlineOptions into HTML.
+func highlightHTML(headline string) string {
+	return headlineReplacer.Replace(html.EscapeString(headline))
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetAll returns a page of the movies in a catalog matching the filter. The
+// search query uses the web search syntax of websearch_to_tsquery: quoted
+// phrases, "or" and "-" for negation. When it is given, the movies can be
+// sorted by relevance, and their headlines are set.
+func (m MovieModel) GetAll(ctx context.Context, orgID int64, filter MovieFilter,
+	filters Filters) ([]*Movie, Metadata, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	return m.getAll(ctx, m.DB, orgID, filter, filters)
+}
+
+// GetAllWithFacets returns the same as GetAll, along with the facets of the
+// movies matching the filter. All of it is read in a single read-only
+// REPEATABLE READ transaction, so that the facets count the same movies as the
+// total in the metadata, even while movies are being changed.
+func (m MovieModel) GetAllWithFacets(ctx context.Context, orgID int64, filter MovieFilter,
+	filters Filters) ([]*Movie, Metadata, *MovieFacets, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+	defer tx.Rollback()
+
+	movies, metadata, err := m.getAll(ctx, tx, orgID, filter, filters)
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	facets, err := m.facets(ctx, tx, orgID, filter)
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	return movies, metadata, facets, nil
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so that the queries of
+// GetAll can also be run as part of GetAllWithFacets.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (m MovieModel) getAll(ctx context.Context, db querier, orgID int64, filter MovieFilter,
+	filters Filters) ([]*Movie, Metadata, error) {
+
+	config := pq.QuoteLiteral(m.SearchConfig)
+
+	where := filter.where(orgID, config, true)
+
+	// With offset paging the total comes from count(*) OVER(), like it always
+	// has. With a cursor the window would only count the rows after the cursor,
+	// so the total is counted separately instead.
+	total := "0"
+	totalRecords := 0
+
+	if filters.IncludeTotal {
+		if filters.Cursor == nil {
+			total = "count(*) OVER()"
+		} else {
+			err := db.QueryRowContext(ctx, "SELECT count(*) FROM movies"+where.String(), where.args...).Scan(&totalRecords)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+	}
+
+	relevance, headline := "0::real", "''"
+
+	if filter.Search != "" {
+		search := where.param(filter.Search)
+
+		relevance = fmt.Sprintf("-ts_rank_cd(to_tsvector(%[1]s, title), websearch_to_tsquery(%[1]s, %[2]s))",
+			config, search)
+		headline = fmt.Sprintf("ts_headline(%[1]s, title, websearch_to_tsquery(%[1]s, %[2]s), %[3]s)",
+			config, search, pq.QuoteLiteral(headlineOptions))
+	}
+
+	if filters.Cursor != nil {
+		column := filters.sortColumn()
+		if column == "relevance" {
+			column = relevance
+		}
+
+		where.add(filters.keysetCondition(column, where.param(filters.Cursor.Value), where.param(filters.Cursor.ID)))
+	}
+
+	// Read one row more than the page size, which tells whether there is
+	// another page after this one.
+	query := fmt.Sprintf(`
+	SELECT %s, id, created_at, title, year, runtime, genres, created_by,
+	COALESCE(organization_id, 0), version, %s AS relevance, %s
+	FROM movies%s
+	ORDER BY %s
+	LIMIT %s OFFSET %s`, total, relevance, headline, where, filters.orderBy(),
+		where.param(filters.limit()+1), where.param(filters.offset()))
+
+	rows, err := db.QueryContext(ctx, query, where.args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -155,26 +331,38 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	movies := []*Movie{}
 
-	totalRecords := 0
 	for rows.Next() {
 		// Initialize an empty Movie struct to hold the data for an
 		var movie Movie
 
+		var rowTotal int
+
 		// Scan the values from the row into the Movie struct. Again, note
 		// using the pq.Array() adapter on the genres field here.
 		err := rows.Scan(
-			&totalRecords,
+			&rowTotal,
 			&movie.ID,
 			&movie.CreatedAt,
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.CreatedBy,
+			&movie.OrganizationID,
 			&movie.Version,
+			&movie.relevance,
+			&movie.Headline,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+
+		movie.Headline = highlightHTML(movie.Headline)
+
+		if filters.Cursor == nil {
+			totalRecords = rowTotal
+		}
+
 		// Add the Movie struct to the slice.
 		movies = append(movies, &movie)
 	}
@@ -183,11 +371,223 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	movies, metadata := paginate(filters, movies, totalRecords, func(movie *Movie) (string, int64) {
+		return movie.sortValue(filters.sortColumn()), movie.ID
+	})
 
 	return movies, metadata, nil
 }
 
+// GetSimilar returns the movies in a catalog whose titles are closest to the
+// text by trigram word similarity, best match first. It is meant for when a
+// search finds nothing, for example because of a typo, and only returns movies
+// above the pg_trgm.word_similarity_threshold (0.6 by default). The title and
+// search query of the filter are ignored, but its other conditions apply.
+func (m MovieModel) GetSimilar(ctx context.Context, orgID int64, text string, filter MovieFilter,
+	limit int) ([]*Movie, error) {
+
+	where := filter.where(orgID, "", false)
+
+	textParam := where.param(text)
+	where.add(textParam + " <% title")
+
+	query := fmt.Sprintf(`
+	SELECT id, created_at, title, year, runtime, genres, created_by,
+	COALESCE(organization_id, 0), version
+	FROM movies%s
+	ORDER BY word_similarity(%s, title) DESC, id ASC
+	LIMIT %s`, where, textParam, where.param(limit))
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, where.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.CreatedBy,
+			&movie.OrganizationID,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// facets counts the movies in a catalog matching the filter per genre, release
+// decade and runtime bucket.
+func (m MovieModel) facets(ctx context.Context, db querier, orgID int64, filter MovieFilter) (*MovieFacets, error) {
+	config := pq.QuoteLiteral(m.SearchConfig)
+
+	decadeFilter, runtimeFilter := filter.facetFilters()
+
+	facets := &MovieFacets{
+		Genres:   []GenreFacet{},
+		Decades:  []DecadeFacet{},
+		Runtimes: []RuntimeFacet{},
+	}
+
+	var genre string
+
+	err := countFacet(ctx, db, "genre", "movies CROSS JOIN LATERAL unnest(genres) AS genre",
+		filter.where(orgID, config, true), "count(*) DESC, value", &genre, func(count int) {
+			facets.Genres = append(facets.Genres, GenreFacet{Genre: genre, Count: count})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var decade int32
+
+	err = countFacet(ctx, db, "year / 10 * 10", "movies",
+		decadeFilter.where(orgID, config, true), "value", &decade, func(count int) {
+			facets.Decades = append(facets.Decades, DecadeFacet{Decade: decade, Count: count})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var bucket int
+
+	where := runtimeFilter.where(orgID, config, true)
+
+	err = countFacet(ctx, db, fmt.Sprintf("width_bucket(runtime, %s::integer[])", where.param(pq.Array(runtimeFacetBounds))),
+		"movies", where, "value", &bucket, func(count int) {
+			facets.Runtimes = append(facets.Runtimes, newRuntimeFacet(bucket, count))
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+// countFacet counts the rows per value of an expression. It scans each value
+// into dest before calling add with its count.
+func countFacet(ctx context.Context, db querier, value, from string, where *whereClause, orderBy string,
+	dest interface{}, add func(count int)) error {
+
+	query := fmt.Sprintf(`
+	SELECT %s AS value, count(*)
+	FROM %s%s
+	GROUP BY value
+	ORDER BY %s`, value, from, where, orderBy)
+
+	rows, err := db.QueryContext(ctx, query, where.args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var count int
+
+		err := rows.Scan(dest, &count)
+		if err != nil {
+			return err
+		}
+
+		add(count)
+	}
+
+	return rows.Err()
+}
+
+// Suggest returns movies in a catalog for a partially typed title: those whose
+// title starts with the prefix or contains a word similar to it. They are
+// ranked by trigram word similarity, plus a small bonus for popularity which
+// grows with the logarithm of the number of catalogs carrying the title. Only
+// that count is taken from the other catalogs, never their movies.
+func (m MovieModel) Suggest(ctx context.Context, orgID int64, prefix string, limit int) ([]*MovieSuggestion, error) {
+	query := `
+	SELECT id, title, year
+	FROM movies
+	WHERE COALESCE(organization_id, 0) = $1
+	AND (title ILIKE $3 OR $2 <% title)
+	ORDER BY word_similarity($2, title) + ln((
+		SELECT count(DISTINCT COALESCE(p.organization_id, 0))
+		FROM movies p
+		WHERE lower(p.title) = lower(movies.title))) / 20 DESC, id ASC
+	LIMIT $4`
+
+	// Escape the wildcards of the ILIKE pattern, so the prefix matches
+	// literally.
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID, prefix, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+
+	for rows.Next() {
+		var suggestion MovieSuggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// sortValue returns the value of one of the columns in the sort safelist, in
+// the form it is kept in a Cursor.
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "relevance":
+		return strconv.FormatFloat(float64(movie.relevance), 'g', -1, 32)
+	default:
+		panic("unsupported sort column: " + column)
+	}
+}
+
 func ValidateMove(v *validator.Validator, movie *Movie) {
 	// Title checks
 	v.Check(movie.Title != "", "title", "must be provided")
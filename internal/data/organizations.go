@@ -0,0 +1,557 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"greenlight/internal/validator"
+	"time"
+)
+
+// The roles a member can have within an organization, from least to most
+// privileged. Viewers can read the organization's catalog, editors can also add
+// movies and change their own, and admins can change any movie and manage the
+// members of the organization.
+const (
+	OrganizationRoleViewer = "viewer"
+	OrganizationRoleEditor = "editor"
+	OrganizationRoleAdmin  = "admin"
+)
+
+var OrganizationRoles = []string{OrganizationRoleViewer, OrganizationRoleEditor, OrganizationRoleAdmin}
+
+// ErrLastAdmin is returned when demoting or removing the only admin of an
+// organization, which must keep at least one.
+var ErrLastAdmin = errors.New("last admin of organization")
+
+// Organization is a tenant with its own movie catalog, isolated from the
+// catalogs of other organizations and from the shared catalog.
+type Organization struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership is the role of a user within an organization. The organization and
+// user details are filled in when memberships are listed.
+type Membership struct {
+	OrganizationID   int64     `json:"organization_id"`
+	OrganizationName string    `json:"organization_name,omitempty"`
+	UserID           int64     `json:"user_id"`
+	Name             string    `json:"name,omitempty"`
+	Email            string    `json:"email,omitempty"`
+	Role             string    `json:"role"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// HasRole reports whether the member's role is at least as privileged as role.
+func (m *Membership) HasRole(role string) bool {
+	return organizationRoleRank(m.Role) >= organizationRoleRank(role)
+}
+
+func organizationRoleRank(role string) int {
+	for i, r := range OrganizationRoles {
+		if r == role {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+func ValidateOrganizationRole(v *validator.Validator, role string) {
+	v.Check(role != "", "role", "must be provided")
+	v.Check(validator.In(role, OrganizationRoles...), "role", "must be one of viewer, editor or admin")
+}
+
+// OrganizationRepository is the set of operations the application performs on
+// organizations, their members, and the organization each user has chosen to
+// work in.
+type OrganizationRepository interface {
+	Insert(ctx context.Context, org *Organization, adminID int64) error
+	Get(ctx context.Context, id int64) (*Organization, error)
+	GetMembership(ctx context.Context, orgID, userID int64) (*Membership, error)
+	GetMembers(ctx context.Context, orgID int64) ([]*Membership, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*Membership, error)
+	UpdateMember(ctx context.Context, membership *Membership) error
+	RemoveMember(ctx context.Context, orgID, userID int64) error
+	GetActive(ctx context.Context, userID int64) (int64, error)
+	SetActive(ctx context.Context, userID, orgID int64) error
+}
+
+type OrganizationModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// Insert creates an organization with the given user as its first admin.
+func (m OrganizationModel) Insert(ctx context.Context, org *Organization, adminID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (name)
+		VALUES ($1)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, org.Name).Scan(&org.ID, &org.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, query, org.ID, adminID, OrganizationRoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m OrganizationModel) Get(ctx context.Context, id int64) (*Organization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, name, created_at
+		FROM organizations
+		WHERE id = $1`
+
+	var org Organization
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &org, nil
+}
+
+// GetMembership returns the membership of a user in an organization, or
+// ErrRecordNotFound if they aren't a member.
+func (m OrganizationModel) GetMembership(ctx context.Context, orgID, userID int64) (*Membership, error) {
+	query := `
+		SELECT organization_id, user_id, role, created_at
+		FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2`
+
+	var membership Membership
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, orgID, userID).Scan(
+		&membership.OrganizationID,
+		&membership.UserID,
+		&membership.Role,
+		&membership.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &membership, nil
+}
+
+// GetMembers returns the members of an organization, with their names and
+// email addresses, in the order they joined.
+func (m OrganizationModel) GetMembers(ctx context.Context, orgID int64) ([]*Membership, error) {
+	query := `
+		SELECT organization_members.organization_id, organizations.name,
+		organization_members.user_id, users.name, users.email,
+		organization_members.role, organization_members.created_at
+		FROM organization_members
+		INNER JOIN organizations ON organizations.id = organization_members.organization_id
+		INNER JOIN users ON users.id = organization_members.user_id
+		WHERE organization_members.organization_id = $1
+		ORDER BY organization_members.created_at, organization_members.user_id`
+
+	return m.getMemberships(ctx, query, orgID)
+}
+
+// GetAllForUser returns the memberships of a user, with the names of the
+// organizations, in the order they joined them.
+func (m OrganizationModel) GetAllForUser(ctx context.Context, userID int64) ([]*Membership, error) {
+	query := `
+		SELECT organization_members.organization_id, organizations.name,
+		organization_members.user_id, users.name, users.email,
+		organization_members.role, organization_members.created_at
+		FROM organization_members
+		INNER JOIN organizations ON organizations.id = organization_members.organization_id
+		INNER JOIN users ON users.id = organization_members.user_id
+		WHERE organization_members.user_id = $1
+		ORDER BY organization_members.created_at, organization_members.organization_id`
+
+	return m.getMemberships(ctx, query, userID)
+}
+
+func (m OrganizationModel) getMemberships(ctx context.Context, query string, args ...interface{}) ([]*Membership, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	memberships := []*Membership{}
+
+	for rows.Next() {
+		var membership Membership
+
+		err := rows.Scan(
+			&membership.OrganizationID,
+			&membership.OrganizationName,
+			&membership.UserID,
+			&membership.Name,
+			&membership.Email,
+			&membership.Role,
+			&membership.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		memberships = append(memberships, &membership)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+// UpdateMember changes the role of a member. It returns ErrLastAdmin if that
+// would leave the organization without an admin.
+func (m OrganizationModel) UpdateMember(ctx context.Context, membership *Membership) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = keepAdmin(ctx, tx, membership.OrganizationID, membership.UserID, membership.Role == OrganizationRoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE organization_members
+		SET role = $1
+		WHERE organization_id = $2 AND user_id = $3`
+
+	_, err = tx.ExecContext(ctx, query, membership.Role, membership.OrganizationID, membership.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember removes a user from an organization. If it was their active
+// organization, they are switched back to the shared catalog. It returns
+// ErrLastAdmin if the user is the organization's only admin.
+func (m OrganizationModel) RemoveMember(ctx context.Context, orgID, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = keepAdmin(ctx, tx, orgID, userID, false)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2`
+
+	_, err = tx.ExecContext(ctx, query, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// keepAdmin locks the memberships of an organization for the rest of the
+// transaction, and checks that the organization will still have an admin once
+// the user is no longer one, unless they stay an admin. Locking every row
+// makes concurrent changes to the organization's members wait for each other,
+// so that two admins can't demote each other at the same time. It returns
+// ErrRecordNotFound if the user isn't a member.
+func keepAdmin(ctx context.Context, tx *sql.Tx, orgID, userID int64, staysAdmin bool) error {
+	query := `
+		SELECT user_id, role
+		FROM organization_members
+		WHERE organization_id = $1
+		FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var (
+		found    bool
+		wasAdmin bool
+		admins   int
+		memberID int64
+		role     string
+	)
+
+	for rows.Next() {
+		err := rows.Scan(&memberID, &role)
+		if err != nil {
+			return err
+		}
+
+		if role == OrganizationRoleAdmin {
+			admins++
+		}
+
+		if memberID == userID {
+			found, wasAdmin = true, role == OrganizationRoleAdmin
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	switch {
+	case !found:
+		return ErrRecordNotFound
+	case wasAdmin && !staysAdmin && admins == 1:
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+// GetActive returns the ID of the organization the user works in by default,
+// or 0 if they work in the shared catalog.
+func (m OrganizationModel) GetActive(ctx context.Context, userID int64) (int64, error) {
+	query := `
+		SELECT organization_id
+		FROM active_organizations
+		WHERE user_id = $1`
+
+	var orgID int64
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&orgID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+
+	return orgID, nil
+}
+
+// SetActive sets the organization the user works in by default, which must be
+// one they are a member of. An orgID of 0 switches them to the shared catalog.
+func (m OrganizationModel) SetActive(ctx context.Context, userID, orgID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	if orgID == 0 {
+		_, err := m.DB.ExecContext(ctx, `DELETE FROM active_organizations WHERE user_id = $1`, userID)
+		return err
+	}
+
+	query := `
+		INSERT INTO active_organizations (user_id, organization_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET organization_id = EXCLUDED.organization_id`
+
+	_, err := m.DB.ExecContext(ctx, query, userID, orgID)
+	return err
+}
+
+// OrganizationInvitation invites the holder of an email address to join an
+// organization with the given role. Like a token, only the SHA-256 hash of the
+// plaintext is stored.
+type OrganizationInvitation struct {
+	Plaintext      string    `json:"-"`
+	Hash           []byte    `json:"-"`
+	OrganizationID int64     `json:"organization_id"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	InvitedBy      int64     `json:"-"`
+	Expiry         time.Time `json:"expiry"`
+}
+
+// OrganizationInvitationRepository is the set of operations the application
+// performs on invitations to join an organization.
+type OrganizationInvitationRepository interface {
+	New(ctx context.Context, orgID int64, email, role string, invitedBy int64,
+		ttl time.Duration) (*OrganizationInvitation, error)
+	Accept(ctx context.Context, tokenPlaintext string, user *User) (*Membership, error)
+}
+
+type OrganizationInvitationModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// New creates an invitation, replacing any earlier one for the same email
+// address to the same organization.
+func (m OrganizationInvitationModel) New(ctx context.Context, orgID int64, email, role string, invitedBy int64,
+	ttl time.Duration) (*OrganizationInvitation, error) {
+
+	token, err := generateToken(invitedBy, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &OrganizationInvitation{
+		Plaintext:      token.Plaintext,
+		Hash:           token.Hash,
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		InvitedBy:      invitedBy,
+		Expiry:         token.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM organization_invitations WHERE organization_id = $1 AND email = $2`,
+		orgID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO organization_invitations (hash, organization_id, email, role, invited_by, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	args := []interface{}{invitation.Hash, orgID, email, role, invitedBy, invitation.Expiry}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// Accept uses up an unexpired invitation sent to the user's email address and
+// makes the user a member of the organization. A user who is already a member
+// keeps their current role. It returns ErrRecordNotFound if there is no such
+// invitation for the user.
+func (m OrganizationInvitationModel) Accept(ctx context.Context, tokenPlaintext string, user *User) (*Membership, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM organization_invitations
+		WHERE hash = $1 AND email = $2 AND expiry > $3
+		RETURNING organization_id, role`
+
+	membership := Membership{UserID: user.ID}
+
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], user.Email, time.Now()).Scan(
+		&membership.OrganizationID,
+		&membership.Role,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, user_id) DO UPDATE
+		SET role = organization_members.role
+		RETURNING role, created_at`
+
+	err = tx.QueryRowContext(ctx, query, membership.OrganizationID, user.ID, membership.Role).Scan(
+		&membership.Role,
+		&membership.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PermissionsChangedChannel is the PostgreSQL notification channel on which the
+// triggers on user_permissions, user_roles and role_permissions announce
+// changes. The payload is the ID of the user whose permissions changed, or "*"
+// if a role changed and any user's permissions may have.
+const PermissionsChangedChannel = "permissions_changed"
+
+// PermissionCache is a bounded, in-process cache of the permissions each user
+// holds. Entries expire after the TTL, and the least recently used entry is
+// evicted once the cache is full. The cache is safe for concurrent use.
+type PermissionCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[int64]*list.Element
+	order   *list.List
+
+	// generation is incremented by every invalidation. A lookup which started
+	// before an invalidation doesn't store its result, as it may be stale.
+	generation uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type permissionCacheEntry struct {
+	userID      int64
+	permissions Permissions
+	expiry      time.Time
+}
+
+// PermissionCacheStats is a snapshot of the cache counters.
+type PermissionCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+func NewPermissionCache(size int, ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[int64]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached permissions of a user, if any, along with the
+// generation to pass to set after a miss.
+func (c *PermissionCache) get(userID int64) (Permissions, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[userID]; ok {
+		entry := elem.Value.(*permissionCacheEntry)
+
+		if time.Now().Before(entry.expiry) {
+			c.order.MoveToFront(elem)
+			c.hits.Add(1)
+			return append(Permissions(nil), entry.permissions...), c.generation, true
+		}
+
+		c.order.Remove(elem)
+		delete(c.entries, userID)
+	}
+
+	c.misses.Add(1)
+	return nil, c.generation, false
+}
+
+// set stores the permissions of a user, unless the cache has been invalidated
+// since the generation was read.
+func (c *PermissionCache) set(userID int64, permissions Permissions, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry := &permissionCacheEntry{
+		userID:      userID,
+		permissions: append(Permissions(nil), permissions...),
+		expiry:      time.Now().Add(c.ttl),
+	}
+
+	if elem, ok := c.entries[userID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[userID] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*permissionCacheEntry).userID)
+	}
+}
+
+// Invalidate drops the cached permissions of a user.
+func (c *PermissionCache) Invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if elem, ok := c.entries[userID]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, userID)
+	}
+}
+
+// InvalidateAll empties the cache.
+func (c *PermissionCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[int64]*list.Element)
+	c.order.Init()
+}
+
+func (c *PermissionCache) Stats() PermissionCacheStats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+
+	return PermissionCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
+
+// Listen subscribes to PermissionsChangedChannel, so that changes made through
+// other API instances (or directly in the database) invalidate the cache. The
+// whole cache is dropped whenever the connection is re-established, as
+// notifications sent while it was down are lost. Errors on the connection are
+// passed to onError. Close the returned listener to stop listening.
+func (c *PermissionCache) Listen(dsn string, onError func(error)) (*pq.Listener, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			onError(err)
+		}
+	})
+
+	err := listener.Listen(PermissionsChangedChannel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go func() {
+		for notification := range listener.Notify {
+			// A nil notification is sent after the connection was re-established.
+			if notification == nil || notification.Extra == "*" {
+				c.InvalidateAll()
+				continue
+			}
+
+			userID, err := strconv.ParseInt(notification.Extra, 10, 64)
+			if err != nil {
+				c.InvalidateAll()
+				continue
+			}
+
+			c.Invalidate(userID)
+		}
+	}()
+
+	return listener, nil
+}
+
+// WithPermissionCache returns a copy of the models in which the permissions
+// held by users are read through the cache. Changes made through the returned
+// models invalidate the cache entries they affect straight away; Listen takes
+// care of changes made elsewhere.
+func (m Models) WithPermissionCache(cache *PermissionCache) Models {
+	m.Permissions = cachedPermissionModel{PermissionRepository: m.Permissions, cache: cache}
+	m.Roles = cachedRoleModel{RoleRepository: m.Roles, cache: cache}
+	m.Users = cachedUserModel{UserRepository: m.Users, cache: cache}
+	return m
+}
+
+type cachedPermissionModel struct {
+	PermissionRepository
+	cache *PermissionCache
+}
+
+func (m cachedPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	permissions, generation, ok := m.cache.get(userID)
+	if ok {
+		return permissions, nil
+	}
+
+	permissions, err := m.PermissionRepository.GetAllForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.cache.set(userID, permissions, generation)
+
+	return permissions, nil
+}
+
+func (m cachedPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	defer m.cache.Invalidate(userID)
+	return m.PermissionRepository.AddForUser(ctx, userID, codes...)
+}
+
+func (m cachedPermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	defer m.cache.Invalidate(userID)
+	return m.PermissionRepository.RemoveForUser(ctx, userID, codes...)
+}
+
+type cachedRoleModel struct {
+	RoleRepository
+	cache *PermissionCache
+}
+
+func (m cachedRoleModel) Update(ctx context.Context, role *Role) error {
+	defer m.cache.InvalidateAll()
+	return m.RoleRepository.Update(ctx, role)
+}
+
+func (m cachedRoleModel) Delete(ctx context.Context, id int64) error {
+	defer m.cache.InvalidateAll()
+	return m.RoleRepository.Delete(ctx, id)
+}
+
+func (m cachedRoleModel) AddForUser(ctx context.Context, userID, roleID int64) error {
+	defer m.cache.Invalidate(userID)
+	return m.RoleRepository.AddForUser(ctx, userID, roleID)
+}
+
+func (m cachedRoleModel) RemoveForUser(ctx context.Context, userID, roleID int64) error {
+	defer m.cache.Invalidate(userID)
+	return m.RoleRepository.RemoveForUser(ctx, userID, roleID)
+}
+
+type cachedUserModel struct {
+	UserRepository
+	cache *PermissionCache
+}
+
+func (m cachedUserModel) Delete(ctx context.Context, id int64) error {
+	defer m.cache.Invalidate(id)
+	return m.UserRepository.Delete(ctx, id)
+}
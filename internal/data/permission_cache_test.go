@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// cachedUserIDs returns the IDs of the users in the cache, most recently used
+// first.
+func cachedUserIDs(c *PermissionCache) []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := []int64{}
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		ids = append(ids, elem.Value.(*permissionCacheEntry).userID)
+	}
+	return ids
+}
+
+func TestPermissionCacheEviction(t *testing.T) {
+	c := NewPermissionCache(2, time.Hour)
+
+	c.set(1, Permissions{"movies:read"}, 0)
+	c.set(2, Permissions{"movies:read"}, 0)
+
+	// Reading user 1 makes user 2 the least recently used.
+	if _, _, ok := c.get(1); !ok {
+		t.Fatal("user 1: got a miss; want a hit")
+	}
+
+	c.set(3, Permissions{"movies:write"}, 0)
+
+	if got := cachedUserIDs(c); !slices.Equal(got, []int64{3, 1}) {
+		t.Errorf("got users %v; want [3 1]", got)
+	}
+
+	if _, _, ok := c.get(2); ok {
+		t.Error("user 2: got a hit; want it evicted")
+	}
+
+	// Replacing an entry doesn't evict another one.
+	c.set(1, Permissions{"movies:write"}, 0)
+
+	if got := cachedUserIDs(c); !slices.Equal(got, []int64{1, 3}) {
+		t.Errorf("after replacing: got users %v; want [1 3]", got)
+	}
+
+	permissions, _, ok := c.get(1)
+	if !ok || !slices.Equal(permissions, Permissions{"movies:write"}) {
+		t.Errorf("user 1: got %v, %t; want the replaced permissions", permissions, ok)
+	}
+}
+
+func TestPermissionCacheExpiry(t *testing.T) {
+	c := NewPermissionCache(10, time.Hour)
+
+	c.set(1, Permissions{"movies:read"}, 0)
+	c.set(2, Permissions{"movies:read"}, 0)
+
+	// Move user 1's entry past its expiry.
+	c.entries[1].Value.(*permissionCacheEntry).expiry = time.Now().Add(-time.Second)
+
+	if _, _, ok := c.get(1); ok {
+		t.Error("expired entry: got a hit; want a miss")
+	}
+
+	if _, _, ok := c.get(2); !ok {
+		t.Error("unexpired entry: got a miss; want a hit")
+	}
+
+	// The expired entry is dropped rather than kept until it is evicted.
+	if got := cachedUserIDs(c); !slices.Equal(got, []int64{2}) {
+		t.Errorf("got users %v; want [2]", got)
+	}
+
+	if got, want := c.Stats(), (PermissionCacheStats{Hits: 1, Misses: 1, Entries: 1}); got != want {
+		t.Errorf("got stats %+v; want %+v", got, want)
+	}
+}
+
+func TestPermissionCacheGeneration(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(c *PermissionCache)
+		wantStored bool
+	}{
+		{"no invalidation", func(c *PermissionCache) {}, true},
+		{"user invalidated", func(c *PermissionCache) { c.Invalidate(1) }, false},
+		{"other user invalidated", func(c *PermissionCache) { c.Invalidate(2) }, false},
+		{"everything invalidated", func(c *PermissionCache) { c.InvalidateAll() }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewPermissionCache(10, time.Hour)
+
+			_, generation, ok := c.get(1)
+			if ok {
+				t.Fatal("got a hit in an empty cache")
+			}
+
+			// The invalidation overlaps the lookup, which then tries to store
+			// what it read before the change.
+			tt.invalidate(c)
+			c.set(1, Permissions{"movies:read"}, generation)
+
+			if _, _, ok := c.get(1); ok != tt.wantStored {
+				t.Errorf("got stored %t; want %t", ok, tt.wantStored)
+			}
+		})
+	}
+}
+
+// racingPermissionModel returns stale permissions after invalidating the cache,
+// as happens when a change is made while it is reading from the database.
+type racingPermissionModel struct {
+	PermissionRepository
+	cache *PermissionCache
+	reads *int
+}
+
+func (m racingPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	*m.reads++
+	if *m.reads == 1 {
+		m.cache.Invalidate(userID)
+		return Permissions{"movies:read", "movies:write"}, nil
+	}
+	return Permissions{"movies:read"}, nil
+}
+
+func TestCachedPermissionModel(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("overlapping invalidation", func(t *testing.T) {
+		cache := NewPermissionCache(10, time.Hour)
+
+		var reads int
+		models := Models{Permissions: racingPermissionModel{cache: cache, reads: &reads}}.WithPermissionCache(cache)
+
+		for i, want := range []Permissions{{"movies:read", "movies:write"}, {"movies:read"}, {"movies:read"}} {
+			got, err := models.Permissions.GetAllForUser(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, want) {
+				t.Errorf("read %d: got %v; want %v", i+1, got, want)
+			}
+		}
+
+		// The stale result wasn't stored, the second one was.
+		if reads != 2 {
+			t.Errorf("got %d reads from the model; want 2", reads)
+		}
+	})
+
+	t.Run("changes invalidate", func(t *testing.T) {
+		cache := NewPermissionCache(10, time.Hour)
+		models := newTestModels(t).WithPermissionCache(cache)
+
+		user := insertTestUser(t, models, "Alice", "alice@example.com", true)
+
+		err := models.Permissions.AddForUser(ctx, user.ID, "movies:read")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := models.Permissions.GetAllForUser(ctx, user.ID)
+		if err != nil || !slices.Equal(got, Permissions{"movies:read"}) {
+			t.Fatalf("got %v, %v; want [movies:read]", got, err)
+		}
+
+		// Changing the returned slice leaves the cached one alone.
+		got[0] = "users:admin"
+
+		err = models.Permissions.AddForUser(ctx, user.ID, "movies:write")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err = models.Permissions.GetAllForUser(ctx, user.ID)
+		if err != nil || !slices.Equal(got, Permissions{"movies:read", "movies:write"}) {
+			t.Errorf("after adding: got %v, %v; want [movies:read movies:write]", got, err)
+		}
+
+		err = models.Permissions.RemoveForUser(ctx, user.ID, "movies:read")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err = models.Permissions.GetAllForUser(ctx, user.ID)
+		if err != nil || !slices.Equal(got, Permissions{"movies:write"}) {
+			t.Errorf("after removing: got %v, %v; want [movies:write]", got, err)
+		}
+	})
+}
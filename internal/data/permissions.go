@@ -21,23 +21,83 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// Intersect returns the permission codes which appear in both p and codes.
+func (p Permissions) Intersect(codes Permissions) Permissions {
+	var permissions Permissions
+
+	for _, code := range p {
+		if codes.Include(code) {
+			permissions = append(permissions, code)
+		}
+	}
+
+	return permissions
+}
+
+// PermissionRepository is the set of operations the application performs on
+// permission codes and the permissions held by users.
+type PermissionRepository interface {
+	GetAll(ctx context.Context) (Permissions, error)
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	GetDirectForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
 type PermissionModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAll returns every known permission code.
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY id`
+
+	return m.getCodes(ctx, query)
+}
+
+// GetAllForUser returns the permissions a user holds, which are those granted
+// to them directly together with those of the roles assigned to them.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
-		INNER JOIN user_permissions ON user_permissions.permission_id =
-		permissions.id
-		INNER JOIN users ON user_permissions.user_id = users.id
-		WHERE users.id = $1`
+		WHERE permissions.id IN (
+			SELECT user_permissions.permission_id
+			FROM user_permissions
+			WHERE user_permissions.user_id = $1
+			UNION
+			SELECT role_permissions.permission_id
+			FROM role_permissions
+			INNER JOIN user_roles ON user_roles.role_id = role_permissions.role_id
+			WHERE user_roles.user_id = $1
+		)
+		ORDER BY permissions.id`
+
+	return m.getCodes(ctx, query, userID)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetDirectForUser returns only the permissions granted to a user directly,
+// leaving out those that come from their roles.
+func (m PermissionModel) GetDirectForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN user_permissions ON user_permissions.permission_id = permissions.id
+		WHERE user_permissions.user_id = $1
+		ORDER BY permissions.id`
+
+	return m.getCodes(ctx, query, userID)
+}
+
+func (m PermissionModel) getCodes(ctx context.Context, query string, args ...interface{}) (Permissions, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -63,13 +123,33 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, err
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+// AddForUser grants permissions to a user directly. Codes which don't exist are
+// skipped, and granting a permission the user already has is not an error.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 		INSERT INTO user_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = 
-		ANY($2)`
+		ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// RemoveForUser revokes permissions granted to a user directly. Permissions the
+// user holds through a role are not affected.
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		DELETE FROM user_permissions
+		USING permissions
+		WHERE user_permissions.permission_id = permissions.id
+		AND user_permissions.user_id = $1
+		AND permissions.code = ANY($2)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
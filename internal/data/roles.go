@@ -0,0 +1,337 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"greenlight/internal/validator"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateRoleName = errors.New("duplicate role name")
+
+// RoleNameRX matches the names that roles can be given.
+var RoleNameRX = regexp.MustCompile("^[a-z0-9][a-z0-9_-]*$")
+
+// Role is a named bundle of permission codes. A user holds the permissions of
+// every role assigned to them, on top of those granted to them directly.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(role.Name, RoleNameRX), "name",
+		"must only contain lowercase letters, digits, hyphens and underscores")
+
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+}
+
+// RoleRepository is the set of operations the application performs on roles
+// and on the roles assigned to users.
+type RoleRepository interface {
+	Insert(ctx context.Context, role *Role) error
+	Get(ctx context.Context, id int64) (*Role, error)
+	GetByName(ctx context.Context, name string) (*Role, error)
+	GetAll(ctx context.Context) ([]*Role, error)
+	Update(ctx context.Context, role *Role) error
+	Delete(ctx context.Context, id int64) error
+	GetAllForUser(ctx context.Context, userID int64) ([]*Role, error)
+	AddForUser(ctx context.Context, userID, roleID int64) error
+	RemoveForUser(ctx context.Context, userID, roleID int64) error
+}
+
+type RoleModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// roleColumns selects a role together with its permission codes, and must be
+// used with the roleJoins and a GROUP BY roles.id clause.
+const (
+	roleColumns = `roles.id, roles.name, roles.created_at,
+		COALESCE(array_agg(permissions.code ORDER BY permissions.id)
+		FILTER (WHERE permissions.code IS NOT NULL), '{}')`
+
+	roleJoins = `LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = role_permissions.permission_id`
+)
+
+// Insert adds a role along with its permissions. Permission codes which don't
+// exist are skipped.
+func (m RoleModel) Insert(ctx context.Context, role *Role) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO roles (name)
+		VALUES ($1)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, role.Name).Scan(&role.ID, &role.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		default:
+			return err
+		}
+	}
+
+	err = setRolePermissions(ctx, tx, role.ID, role.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setRolePermissions replaces the permissions of a role.
+func setRolePermissions(ctx context.Context, tx *sql.Tx, roleID int64, codes Permissions) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, roleID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO role_permissions (role_id, permission_id)
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, roleID, pq.Array(codes))
+	return err
+}
+
+func (m RoleModel) Get(ctx context.Context, id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + roleColumns + `
+		FROM roles
+		` + roleJoins + `
+		WHERE roles.id = $1
+		GROUP BY roles.id`
+
+	return m.getOne(ctx, query, id)
+}
+
+func (m RoleModel) GetByName(ctx context.Context, name string) (*Role, error) {
+	query := `
+		SELECT ` + roleColumns + `
+		FROM roles
+		` + roleJoins + `
+		WHERE roles.name = $1
+		GROUP BY roles.id`
+
+	return m.getOne(ctx, query, name)
+}
+
+func (m RoleModel) getOne(ctx context.Context, query string, args ...interface{}) (*Role, error) {
+	var role Role
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&role.ID,
+		&role.Name,
+		&role.CreatedAt,
+		pq.Array(&role.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// GetAll returns every role, ordered by ID.
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	query := `
+		SELECT ` + roleColumns + `
+		FROM roles
+		` + roleJoins + `
+		GROUP BY roles.id
+		ORDER BY roles.id`
+
+	return m.getMany(ctx, query)
+}
+
+// GetAllForUser returns the roles assigned to a user, ordered by ID.
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]*Role, error) {
+	query := `
+		SELECT ` + roleColumns + `
+		FROM roles
+		INNER JOIN user_roles ON user_roles.role_id = roles.id
+		` + roleJoins + `
+		WHERE user_roles.user_id = $1
+		GROUP BY roles.id
+		ORDER BY roles.id`
+
+	return m.getMany(ctx, query, userID)
+}
+
+func (m RoleModel) getMany(ctx context.Context, query string, args ...interface{}) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.CreatedAt,
+			pq.Array(&role.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Update renames a role and replaces its permissions.
+func (m RoleModel) Update(ctx context.Context, role *Role) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE roles SET name = $1 WHERE id = $2`, role.Name, role.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = setRolePermissions(ctx, tx, role.ID, role.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a role, which takes it away from every user it was assigned to.
+func (m RoleModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM roles
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddForUser assigns a role to a user. Assigning a role the user already has is
+// not an error.
+func (m RoleModel) AddForUser(ctx context.Context, userID, roleID int64) error {
+	query := `
+		INSERT INTO user_roles (user_id, role_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, roleID)
+	return err
+}
+
+// RemoveForUser takes a role away from a user, returning ErrRecordNotFound if
+// the user didn't have it.
+func (m RoleModel) RemoveForUser(ctx context.Context, userID, roleID int64) error {
+	query := `
+		DELETE FROM user_roles
+		WHERE user_id = $1 AND role_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
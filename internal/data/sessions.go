@@ -0,0 +1,321 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Session is the view of a token family that is shown to its owner: the access
+// and refresh tokens issued from a single login. It is identified by the family,
+// never by a token hash, so the ID stays the same when the tokens are rotated.
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
+}
+
+// sessionScopes are the token scopes which make up a session.
+var sessionScopes = []string{ScopeAuthentication, ScopeRefresh}
+
+// generateTokenPair generates an access token and a refresh token for the given
+// client. The tokens join the given family, or start a new one if it is empty.
+// If accessTTL is zero only the refresh token is generated and the returned
+// access token is nil, for callers which issue signed access tokens instead.
+func generateTokenPair(userID int64, family string, accessTTL, refreshTTL time.Duration,
+	ip, userAgent string) (*Token, *Token, error) {
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens := []*Token{refresh}
+
+	var access *Token
+	if accessTTL > 0 {
+		access, err = generateToken(userID, accessTTL, ScopeAuthentication)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tokens = append(tokens, access)
+	}
+
+	if family == "" {
+		family = tokens[len(tokens)-1].ID
+	}
+
+	for _, token := range tokens {
+		token.Family = family
+		token.IP = ip
+		token.UserAgent = userAgent
+	}
+
+	return access, refresh, nil
+}
+
+// pairTokens returns the non-nil tokens of a pair made by generateTokenPair.
+func pairTokens(access, refresh *Token) []*Token {
+	if access == nil {
+		return []*Token{refresh}
+	}
+
+	return []*Token{access, refresh}
+}
+
+// NewSession starts a new session by creating an access token and a refresh
+// token in a new family, recording the IP address and User-Agent of the client
+// they are issued to. See generateTokenPair for the meaning of a zero accessTTL.
+func (m TokenModel) NewSession(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration,
+	ip, userAgent string) (*Token, *Token, error) {
+
+	access, refresh, err := generateTokenPair(userID, "", accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	for _, token := range pairTokens(access, refresh) {
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// Rotate exchanges a refresh token for a new access token and a new refresh
+// token in the same family. The old refresh token is kept, marked as used, and
+// the family's previous access tokens are deleted. If a refresh token that has
+// already been used is presented again, the whole family is revoked and
+// ErrRefreshTokenReused is returned.
+func (m TokenModel) Rotate(ctx context.Context, refreshTokenPlaintext string, accessTTL, refreshTTL time.Duration,
+	ip, userAgent string) (*Token, *Token, error) {
+
+	tokenHash := sha256.Sum256([]byte(refreshTokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the refresh token row, so that concurrent attempts to exchange the
+	// same token are handled one after the other.
+	query := `
+		SELECT user_id, family, used_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3
+		FOR UPDATE`
+
+	var (
+		userID int64
+		family string
+		usedAt *time.Time
+	)
+
+	now := time.Now()
+
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, now).Scan(&userID, &family, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if usedAt != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, family)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = $1 WHERE hash = $2`, now, tokenHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1 AND scope = $2`,
+		family, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := generateTokenPair(userID, family, accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range pairTokens(access, refresh) {
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// GetSessionsForUser returns the sessions of a user which still hold an
+// unexpired access token or unused refresh token, most recently created first.
+// The session that currentTokenPlaintext belongs to is flagged as the current
+// one.
+func (m TokenModel) GetSessionsForUser(ctx context.Context, userID int64, currentTokenPlaintext string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentTokenPlaintext))
+
+	query := `
+		SELECT family,
+			min(created_at),
+			max(last_used_at),
+			max(expiry) FILTER (WHERE used_at IS NULL),
+			(array_agg(ip ORDER BY created_at DESC))[1],
+			(array_agg(user_agent ORDER BY created_at DESC))[1],
+			bool_or(hash = $2)
+		FROM tokens
+		WHERE user_id = $1 AND scope = ANY($3) AND expiry > $4
+		GROUP BY family
+		HAVING bool_or(used_at IS NULL)
+		ORDER BY min(created_at) DESC, family`
+
+	args := []interface{}{userID, currentHash[:], pq.Array(sessionScopes), time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession revokes every token in the session with the given ID. It
+// returns ErrRecordNotFound if the session doesn't exist or belongs to another
+// user.
+func (m TokenModel) DeleteSession(ctx context.Context, userID int64, id string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1 AND user_id = $2 AND scope = ANY($3)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, pq.Array(sessionScopes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteOtherSessions revokes every session of the user except the one with the
+// given ID.
+func (m TokenModel) DeleteOtherSessions(ctx context.Context, userID int64, keepID string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope = ANY($2) AND family <> $3`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(sessionScopes), keepID)
+	return err
+}
+
+// Touch records that an authentication token has just been used. The row is
+// only written if it hasn't been touched within the given interval, so that
+// instances sharing the database don't all update it on every request.
+func (m TokenModel) Touch(ctx context.Context, tokenPlaintext string, interval time.Duration) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE tokens
+		SET last_used_at = $1
+		WHERE hash = $2 AND (last_used_at IS NULL OR last_used_at < $3)`
+
+	now := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, now, tokenHash[:], now.Add(-interval))
+	return err
+}
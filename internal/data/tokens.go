@@ -6,6 +6,8 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"encoding/hex"
+	"errors"
 	"greenlight/internal/validator"
 	"time"
 )
@@ -13,14 +15,37 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeEmailChange    = "email-change"
+	ScopeMFAPending     = "mfa-pending"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
 )
 
+// ErrRefreshTokenReused is returned when a refresh token which has already been
+// exchanged is presented again, which means that it has probably been stolen.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+
+	// ID identifies the token without revealing its hash, and the remaining
+	// fields describe the client that the token was issued to. They are used to
+	// present authentication tokens as sessions.
+	ID         string     `json:"-"`
+	CreatedAt  time.Time  `json:"-"`
+	LastUsedAt *time.Time `json:"-"`
+	IP         string     `json:"-"`
+	UserAgent  string     `json:"-"`
+
+	// Family groups the access and refresh tokens descended from a single
+	// login. A token starts its own family unless it is issued as part of an
+	// existing one. UsedAt is set once a refresh token has been exchanged.
+	Family string     `json:"-"`
+	UsedAt *time.Time `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -38,6 +63,18 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		return nil, err
 	}
 
+	// Generate a separate random ID for the token. Unlike the hash, this can be
+	// shown to the user and used to refer to the token later on.
+	idBytes := make([]byte, 12)
+
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ID = hex.EncodeToString(idBytes)
+	token.Family = token.ID
+
 	// Encode the byte slice to a base-32-encoded string and assign it to the token
 	// Plaintext field. This will be the token string that we send to the user in their
 	// welcome email. They will look similar to this:
@@ -61,41 +98,127 @@ func VaidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
+// TokenRepository is the set of operations the application performs on tokens.
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	NewSession(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*Token, *Token, error)
+	Rotate(ctx context.Context, refreshTokenPlaintext string, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*Token, *Token, error)
+	Insert(ctx context.Context, token *Token) error
+	Consume(ctx context.Context, scope, tokenPlaintext string) (int64, error)
+	DeleteFamily(ctx context.Context, scope, tokenPlaintext string) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	GetSessionsForUser(ctx context.Context, userID int64, currentTokenPlaintext string) ([]*Session, error)
+	DeleteSession(ctx context.Context, userID int64, id string) error
+	DeleteOtherSessions(ctx context.Context, userID int64, keepID string) error
+	Touch(ctx context.Context, tokenPlaintext string, interval time.Duration) error
+}
+
 // Define the TokenModel type.
 type TokenModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx, so that insertToken can
+// be used inside and outside of a transaction.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func insertToken(ctx context.Context, db queryRower, token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, id, ip, user_agent, family)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at`
+
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope,
+		token.ID, token.IP, token.UserAgent, token.Family}
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	return db.QueryRowContext(ctx, query, args...).Scan(&token.CreatedAt)
+}
+
+// DeleteFamily removes the token with the given scope and plaintext together
+// with every other token in its family, which ends the session the token
+// belongs to. It returns ErrRecordNotFound if there is no such token.
+// Consume deletes an unexpired token of the given scope and returns the ID of
+// its user. The token is found and deleted by a single statement, so when the
+// same token is presented by several requests at once only one of them gets it.
+func (m TokenModel) Consume(ctx context.Context, scope, tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3
+		RETURNING user_id`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	var userID int64
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func (m TokenModel) DeleteFamily(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
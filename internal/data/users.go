@@ -5,10 +5,12 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"greenlight/internal/passhash"
 	"greenlight/internal/validator"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 var (
@@ -37,12 +39,26 @@ type password struct {
 	hash      []byte
 }
 
+// UserRepository is the set of operations the application performs on users.
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	Register(ctx context.Context, user *User, roleID int64, invitationCode string) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	GetAll(ctx context.Context, search string, activated *bool, filters Filters) ([]*User, Metadata, error)
+	Delete(ctx context.Context, id int64) error
+}
+
 type UserModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
+// Set hashes the plaintext password with the default password hasher.
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := passhash.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -53,18 +69,17 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// Matches checks the plaintext password against the hash, using whichever
+// algorithm the hash was made with.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, nil
-		}
-	}
+	return passhash.Matches(plaintextPassword, p.hash)
+}
 
-	return true, nil
+// NeedsRehash reports whether the hash was made with an older algorithm or
+// weaker parameters than the default password hasher uses. The password
+// should then be Set again the next time the plaintext is known.
+func (p *password) NeedsRehash() bool {
+	return passhash.NeedsRehash(p.hash)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -75,7 +90,7 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= passhash.MaxLength(), "password", fmt.Sprintf("must not be more than %d bytes long", passhash.MaxLength()))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -101,7 +116,14 @@ func ValidateUser(v *validator.Validator, user *User) {
 // If the insertion is successful, it populates the user struct with the generated ID, creation timestamp, and version.
 // If the email already exists in the database, it returns ErrDuplicateEmail.
 // If any other error occurs during the execution of the query, it returns that error.
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
+
+func insertUser(ctx context.Context, db queryRower, user *User) error {
 	query := `
 	INSERT INTO users (name, email, password_hash, activated)
 	VALUES ($1, $2, $3, $4)
@@ -111,10 +133,7 @@ func (m UserModel) Insert(user *User) error {
 		user.Name, user.Email, user.Password.hash, user.Activated,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -127,7 +146,98 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+// Register inserts a new user together with what registering gives them, in a
+// single transaction: a use of the invitation code, if one is given, the role
+// with the given ID, unless it is zero, and the permissions of the code. Either
+// all of it happens or none of it does. It returns ErrInvalidInvitationCode if
+// the code can't be used by the user's email address.
+func (m UserModel) Register(ctx context.Context, user *User, roleID int64, invitationCode string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var code *InvitationCode
+
+	if invitationCode != "" {
+		code, err = redeemInvitationCode(ctx, tx, invitationCode, user.Email)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	if roleID != 0 {
+		query := `
+			INSERT INTO user_roles (user_id, role_id)
+			VALUES ($1, $2)`
+
+		_, err = tx.ExecContext(ctx, query, user.ID, roleID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if code != nil && len(code.Permissions) > 0 {
+		query := `
+			INSERT INTO user_permissions
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+		_, err = tx.ExecContext(ctx, query, user.ID, pq.Array(code.Permissions))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 	SELECT id, created_at, name, email, password_hash, activated, version
 	FROM users
@@ -135,7 +245,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -160,7 +270,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
 	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -176,7 +286,7 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -195,7 +305,7 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
@@ -213,7 +323,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -237,3 +347,90 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// GetAll returns a page of users whose email address or name contains search,
+// ignoring case. If activated is not nil, only users with that activation state
+// are returned.
+func (m UserModel) GetAll(ctx context.Context, search string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE ($1 = '' OR strpos(lower(email), lower($1)) > 0 OR strpos(lower(name), lower($1)) > 0)
+		AND ($2::boolean IS NULL OR activated = $2)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	args := []interface{}{search, activated, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	totalRecords := 0
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// Delete deletes a user. Their tokens, permissions, API keys and other records
+// are deleted along with them by the ON DELETE CASCADE foreign keys.
+func (m UserModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM users
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
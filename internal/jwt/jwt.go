@@ -0,0 +1,246 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The algorithms supported for signing tokens, using the names from RFC 7518 and
+// RFC 8037.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// Claims is the payload of a signed access token. It carries everything the
+// application needs to authorize a request without looking the user up.
+// OrganizationID is the user's active organization when the token was signed,
+// or 0 for the shared catalog, and Roles holds the user's role in each of the
+// organizations they are a member of, keyed by organization ID.
+type Claims struct {
+	UserID         int64            `json:"sub"`
+	Activated      bool             `json:"act"`
+	Permissions    []string         `json:"perms"`
+	SessionID      string           `json:"sid"`
+	OrganizationID int64            `json:"org,omitempty"`
+	Roles          map[int64]string `json:"roles,omitempty"`
+	IssuedAt       int64            `json:"iat"`
+	Expiry         int64            `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+// Key is a named key used to sign and verify tokens.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// ParseKey parses a key in the form "id:algorithm:base64-key". The algorithm is
+// either "hs256", with a secret of at least 32 bytes, or "ed25519", with a
+// 32-byte seed or 64-byte private key.
+func ParseKey(s string) (*Key, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return nil, errors.New("jwt: key must be in the form id:algorithm:base64-key")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		raw, err = base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q is not valid base64", parts[0])
+		}
+	}
+
+	key := &Key{ID: parts[0]}
+
+	switch strings.ToLower(parts[1]) {
+	case "hs256":
+		if len(raw) < 32 {
+			return nil, fmt.Errorf("jwt: hs256 key %q must be at least 32 bytes long", key.ID)
+		}
+		key.Algorithm = AlgorithmHS256
+		key.secret = raw
+
+	case "ed25519":
+		switch len(raw) {
+		case ed25519.SeedSize:
+			key.privateKey = ed25519.NewKeyFromSeed(raw)
+		case ed25519.PrivateKeySize:
+			key.privateKey = ed25519.PrivateKey(raw)
+		default:
+			return nil, fmt.Errorf("jwt: ed25519 key %q must be a 32-byte seed or 64-byte private key", key.ID)
+		}
+		key.Algorithm = AlgorithmEdDSA
+		key.publicKey = key.privateKey.Public().(ed25519.PublicKey)
+
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q for key %q", parts[1], key.ID)
+	}
+
+	return key, nil
+}
+
+func (k *Key) sign(signingInput []byte) []byte {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil)
+	default:
+		return ed25519.Sign(k.privateKey, signingInput)
+	}
+}
+
+func (k *Key) verify(signingInput, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		return hmac.Equal(k.sign(signingInput), signature)
+	default:
+		return ed25519.Verify(k.publicKey, signingInput, signature)
+	}
+}
+
+// KeySet signs tokens with one key and verifies them with any of its keys. Keys
+// are rotated by adding a new key, making it the signing key, and removing the
+// old one once the tokens it signed have expired.
+type KeySet struct {
+	signingKey *Key
+	keys       map[string]*Key
+}
+
+// NewKeySet returns a KeySet which signs with the key named signingKeyID. If
+// signingKeyID is empty the first key is used.
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: at least one key is required")
+	}
+
+	ks := &KeySet{keys: make(map[string]*Key)}
+
+	for _, key := range keys {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	if signingKeyID == "" {
+		signingKeyID = keys[0].ID
+	}
+
+	ks.signingKey = ks.keys[signingKeyID]
+	if ks.signingKey == nil {
+		return nil, fmt.Errorf("jwt: signing key %q is not configured", signingKeyID)
+	}
+
+	return ks, nil
+}
+
+// Sign returns the claims as a compact JWS, signed with the signing key.
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	h := header{
+		Algorithm: ks.signingKey.Algorithm,
+		KeyID:     ks.signingKey.ID,
+		Type:      "JWT",
+	}
+
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+	signature := ks.signingKey.sign([]byte(signingInput))
+
+	return signingInput + "." + encode(signature), nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims. The
+// algorithm in the token header must match the algorithm of the key it names,
+// so a token can't pick a weaker way of being verified.
+func (ks *KeySet) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJSON, err := decode(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := json.Unmarshal(headerJSON, &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := ks.keys[h.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := decode(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	claimsJSON, err := decode(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
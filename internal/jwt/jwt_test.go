@@ -0,0 +1,223 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	hs256Key   = "hs:hs256:" + base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	ed25519Key = "ed:ed25519:" + base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+// newTestKeySet returns a KeySet holding an HS256 key with ID "hs" and an
+// EdDSA key with ID "ed", which signs with the key named signingKeyID.
+func newTestKeySet(t *testing.T, signingKeyID string) *KeySet {
+	t.Helper()
+
+	var keys []*Key
+
+	for _, s := range []string{hs256Key, ed25519Key} {
+		key, err := ParseKey(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	ks, err := NewKeySet(signingKeyID, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ks
+}
+
+// forge returns a token with the given header and claims segments, signed with
+// the signature function.
+func forge(headerJSON, claimsJSON string, sign func(signingInput []byte) []byte) string {
+	signingInput := encode([]byte(headerJSON)) + "." + encode([]byte(claimsJSON))
+	return signingInput + "." + encode(sign([]byte(signingInput)))
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantAlg string
+	}{
+		{"hs256", hs256Key, AlgorithmHS256},
+		{"ed25519 seed", ed25519Key, AlgorithmEdDSA},
+		{"ed25519 private key", "ed:ed25519:" + base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(make([]byte, 32))), AlgorithmEdDSA},
+		{"raw url base64", "hs:HS256:" + base64.RawURLEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")), AlgorithmHS256},
+		{"missing id", ":hs256:" + strings.SplitN(hs256Key, ":", 3)[2], ""},
+		{"missing key", "hs:hs256", ""},
+		{"bad base64", "hs:hs256:!!!", ""},
+		{"short hs256 secret", "hs:hs256:" + base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		{"short ed25519 seed", "ed:ed25519:" + base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		{"unsupported algorithm", "rs:rs256:" + strings.SplitN(hs256Key, ":", 3)[2], ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.key)
+
+			if tt.wantAlg == "" {
+				if err == nil {
+					t.Fatalf("got key %+v; want an error", key)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key.Algorithm != tt.wantAlg {
+				t.Errorf("got algorithm %q; want %q", key.Algorithm, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	key, err := ParseKey(hs256Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewKeySet(""); err == nil {
+		t.Error("no keys: got no error")
+	}
+
+	if _, err := NewKeySet("", key, key); err == nil {
+		t.Error("duplicate keys: got no error")
+	}
+
+	if _, err := NewKeySet("other", key); err == nil {
+		t.Error("unknown signing key: got no error")
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	claims := Claims{
+		UserID:         1,
+		Activated:      true,
+		Permissions:    []string{"movies:read"},
+		SessionID:      "session",
+		OrganizationID: 2,
+		Roles:          map[int64]string{2: "admin", 3: "viewer"},
+		IssuedAt:       now.Unix(),
+		Expiry:         now.Add(time.Minute).Unix(),
+	}
+
+	for _, signingKeyID := range []string{"hs", "ed"} {
+		t.Run(signingKeyID, func(t *testing.T) {
+			token, err := newTestKeySet(t, signingKeyID).Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Tokens signed with any key of the set are verified, so that keys
+			// can be rotated.
+			for _, verifyingKeyID := range []string{"hs", "ed"} {
+				got, err := newTestKeySet(t, verifyingKeyID).Verify(token, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got.UserID != claims.UserID || got.SessionID != claims.SessionID || got.Roles[2] != "admin" ||
+					got.Roles[3] != "viewer" || len(got.Permissions) != 1 {
+					t.Errorf("got claims %+v; want %+v", got, claims)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	ks := newTestKeySet(t, "hs")
+
+	valid, err := ks.Sign(Claims{UserID: 1, Expiry: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := ks.Sign(Claims{UserID: 1, Expiry: now.Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresNow, err := ks.Sign(Claims{UserID: 1, Expiry: now.Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ".")
+	claimsJSON := `{"sub":1,"exp":1700000060}`
+	adminJSON := `{"sub":1,"perms":["users:admin"],"exp":1700000060}`
+
+	edPublicKey := ks.keys["ed"].publicKey
+	hsSecret := ks.keys["hs"].secret
+	otherEdKey := ed25519.NewKeyFromSeed(make([]byte, 32))
+
+	hmacWith := func(secret []byte) func([]byte) []byte {
+		return func(signingInput []byte) []byte {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(signingInput)
+			return mac.Sum(nil)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		// An HS256 header on the EdDSA key would let anyone who knows the
+		// public key sign tokens with it as an HMAC secret.
+		{"hs256 header on an eddsa key", forge(`{"alg":"HS256","kid":"ed","typ":"JWT"}`, claimsJSON, hmacWith(edPublicKey)), ErrInvalidToken},
+		{"eddsa header on an hs256 key", forge(`{"alg":"EdDSA","kid":"hs","typ":"JWT"}`, claimsJSON, func(b []byte) []byte {
+			return ed25519.Sign(otherEdKey, b)
+		}), ErrInvalidToken},
+		{"alg none", forge(`{"alg":"none","kid":"hs","typ":"JWT"}`, claimsJSON, func([]byte) []byte { return nil }), ErrInvalidToken},
+		{"alg none without a signature segment", encode([]byte(`{"alg":"none","kid":"hs"}`)) + "." + encode([]byte(claimsJSON)) + ".", ErrInvalidToken},
+		{"unknown kid", forge(`{"alg":"HS256","kid":"other","typ":"JWT"}`, claimsJSON, hmacWith(hsSecret)), ErrUnknownKey},
+		{"missing kid", forge(`{"alg":"HS256","typ":"JWT"}`, claimsJSON, hmacWith(hsSecret)), ErrUnknownKey},
+		{"tampered payload", parts[0] + "." + encode([]byte(adminJSON)) + "." + parts[2], ErrInvalidToken},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + encode([]byte("not the signature")), ErrInvalidToken},
+		{"signature with another secret", forge(`{"alg":"HS256","kid":"hs","typ":"JWT"}`, claimsJSON, hmacWith([]byte("another secret"))), ErrInvalidToken},
+		{"malformed header", encode([]byte("{")) + "." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"malformed base64", "!!!." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"expired", expired, ErrExpiredToken},
+		{"expires now", expiresNow, ErrExpiredToken},
+		{"two segments", parts[0] + "." + parts[1], ErrInvalidToken},
+		{"four segments", valid + "." + parts[2], ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ks.Verify(tt.token, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got claims %+v, error %v; want %v", claims, err, tt.wantErr)
+			}
+		})
+	}
+
+	// The signed payload itself still passes, so the rejections above come
+	// from what was changed.
+	if _, err := ks.Verify(forge(`{"alg":"HS256","kid":"hs","typ":"JWT"}`, claimsJSON, hmacWith(hsSecret)), now); err != nil {
+		t.Errorf("forged with the right secret: got %v; want no error", err)
+	}
+}
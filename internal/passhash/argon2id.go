@@ -0,0 +1,124 @@
+package passhash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var argon2idPrefix = []byte("$argon2id$")
+
+// Argon2id hashes passwords with Argon2id, encoding them in PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+type Argon2id struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+// NewArgon2id returns an Argon2id hasher using the parameters recommended by
+// RFC 9106 for memory-constrained environments.
+func NewArgon2id() Argon2id {
+	return Argon2id{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (a Argon2id) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, a.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations,
+		a.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func (a Argon2id) Matches(plaintext string, hash []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (a Argon2id) Identifies(hash []byte) bool {
+	return bytes.HasPrefix(hash, argon2idPrefix)
+}
+
+func (a Argon2id) NeedsRehash(hash []byte) bool {
+	params, salt, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != a.Memory || params.Iterations != a.Iterations ||
+		params.Parallelism != a.Parallelism || params.KeyLength != a.KeyLength || len(salt) != a.SaltLength
+}
+
+// MaxLength is not imposed by Argon2id itself, but bounds the work done for
+// each password.
+func (a Argon2id) MaxLength() int {
+	return 1024
+}
+
+// decodeArgon2id parses an encoded Argon2id hash into its parameters, salt and
+// key.
+func decodeArgon2id(hash []byte) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+
+	// The encoded hash splits into "", "argon2id", "v=19", "m=...,t=...,p=...",
+	// the salt and the key.
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	params.SaltLength = len(salt)
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
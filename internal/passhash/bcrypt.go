@@ -0,0 +1,61 @@
+package passhash
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt hashes passwords with bcrypt. It is kept so that the hashes of
+// existing users can still be verified.
+type Bcrypt struct {
+	Cost int
+}
+
+// NewBcrypt returns a bcrypt hasher with a cost of 12.
+func NewBcrypt() Bcrypt {
+	return Bcrypt{Cost: 12}
+}
+
+func (b Bcrypt) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), b.Cost)
+}
+
+func (b Bcrypt) Matches(plaintext string, hash []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword), errors.Is(err, bcrypt.ErrPasswordTooLong):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (b Bcrypt) Identifies(hash []byte) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if bytes.HasPrefix(hash, []byte(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b Bcrypt) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	if err != nil {
+		return true
+	}
+
+	return cost < b.Cost
+}
+
+// MaxLength is the length beyond which bcrypt ignores the rest of the password.
+func (b Bcrypt) MaxLength() int {
+	return 72
+}
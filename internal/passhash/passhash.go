@@ -0,0 +1,101 @@
+// Package passhash hashes passwords into self-describing strings: Argon2id
+// hashes in PHC string format and bcrypt hashes in their modular crypt format.
+// Any supported hash can be verified, whichever algorithm is used for new ones.
+package passhash
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("passhash: unknown hash algorithm")
+	ErrInvalidHash      = errors.New("passhash: invalid hash")
+)
+
+// Hasher is a password hashing algorithm with a particular set of parameters.
+type Hasher interface {
+	// Hash returns the encoded hash of the password, including its salt and
+	// the parameters used.
+	Hash(plaintext string) ([]byte, error)
+
+	// Matches reports whether the password matches an encoded hash made by
+	// this algorithm, whatever parameters were used.
+	Matches(plaintext string, hash []byte) (bool, error)
+
+	// Identifies reports whether the encoded hash was made by this algorithm.
+	Identifies(hash []byte) bool
+
+	// NeedsRehash reports whether a hash made by this algorithm was made with
+	// parameters other than the hasher's own.
+	NeedsRehash(hash []byte) bool
+
+	// MaxLength is the length in bytes of the longest password accepted.
+	MaxLength() int
+}
+
+// Default is the hasher used for new hashes. Hashes made by the other supported
+// algorithms are still verified, and are reported by NeedsRehash.
+var Default Hasher = NewArgon2id()
+
+// hashers lists the algorithms that can be verified.
+var hashers = []Hasher{NewArgon2id(), NewBcrypt()}
+
+// New returns the hasher for an algorithm name, with the recommended parameters.
+func New(name string) (Hasher, error) {
+	switch name {
+	case "argon2id":
+		return NewArgon2id(), nil
+	case "bcrypt":
+		return NewBcrypt(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, name)
+	}
+}
+
+// Hash hashes the password with the Default hasher.
+func Hash(plaintext string) ([]byte, error) {
+	return Default.Hash(plaintext)
+}
+
+// Matches reports whether the password matches the encoded hash, using the
+// algorithm that made it.
+func Matches(plaintext string, hash []byte) (bool, error) {
+	hasher, err := identify(hash)
+	if err != nil {
+		return false, err
+	}
+
+	return hasher.Matches(plaintext, hash)
+}
+
+// NeedsRehash reports whether the encoded hash was made by an algorithm or with
+// parameters other than those of the Default hasher, in which case the password
+// should be hashed again the next time it is known.
+func NeedsRehash(hash []byte) bool {
+	if !Default.Identifies(hash) {
+		return true
+	}
+
+	return Default.NeedsRehash(hash)
+}
+
+// MaxLength is the length in bytes of the longest password accepted by the
+// Default hasher.
+func MaxLength() int {
+	return Default.MaxLength()
+}
+
+func identify(hash []byte) (Hasher, error) {
+	if Default.Identifies(hash) {
+		return Default, nil
+	}
+
+	for _, hasher := range hashers {
+		if hasher.Identifies(hash) {
+			return hasher, nil
+		}
+	}
+
+	return nil, ErrUnknownAlgorithm
+}
@@ -0,0 +1,225 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// cheapArgon2id keeps the tests fast. Its parameters differ from those of the
+// Default hasher in every field but the salt length.
+var cheapArgon2id = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// cheapBcrypt uses the lowest cost bcrypt accepts.
+var cheapBcrypt = Bcrypt{Cost: 4}
+
+func mustHash(t *testing.T, h Hasher, plaintext string) []byte {
+	t.Helper()
+
+	hash, err := h.Hash(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestArgon2idEncoding(t *testing.T) {
+	hash := mustHash(t, cheapArgon2id, "pa55word")
+
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("got hash %q; want it to start with the PHC parameters", hash)
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params != cheapArgon2id || len(salt) != 16 || len(key) != 32 {
+		t.Errorf("got %+v with a %d-byte salt and %d-byte key; want %+v", params, len(salt), len(key), cheapArgon2id)
+	}
+
+	for _, tt := range []struct {
+		plaintext string
+		want      bool
+	}{
+		{"pa55word", true},
+		{"pa55wore", false},
+		{"", false},
+	} {
+		got, err := cheapArgon2id.Matches(tt.plaintext, hash)
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %t, %v; want %t", tt.plaintext, got, err, tt.want)
+		}
+	}
+
+	// The parameters are read from the hash, not from the hasher.
+	got, err := NewArgon2id().Matches("pa55word", hash)
+	if err != nil || !got {
+		t.Errorf("other parameters: got %t, %v; want true", got, err)
+	}
+
+	if a, b := mustHash(t, cheapArgon2id, "pa55word"), hash; string(a) == string(b) {
+		t.Error("got the same hash twice; want a new salt each time")
+	}
+}
+
+func TestDecodeArgon2idMalformed(t *testing.T) {
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"other algorithm", "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key},
+		{"missing key", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"extra part", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$"},
+		{"missing version", "$argon2id$m=64,t=1,p=1$" + salt + "$" + key},
+		{"old version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{"malformed parameters", "$argon2id$v=19$m=64;t=1;p=1$" + salt + "$" + key},
+		{"no iterations", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{"no parallelism", "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key},
+		{"padded salt", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "==$" + key},
+		{"invalid key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!"},
+		{"empty key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := decodeArgon2id([]byte(tt.hash))
+			if !errors.Is(err, ErrInvalidHash) {
+				t.Errorf("got %v; want %v", err, ErrInvalidHash)
+			}
+
+			if !cheapArgon2id.NeedsRehash([]byte(tt.hash)) {
+				t.Error("got NeedsRehash false; want true")
+			}
+		})
+	}
+
+	if _, _, _, err := decodeArgon2id([]byte("$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key)); err != nil {
+		t.Errorf("well-formed hash: got %v; want no error", err)
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hash := mustHash(t, cheapArgon2id, "pa55word")
+
+	tests := []struct {
+		name   string
+		change func(a *Argon2id)
+		want   bool
+	}{
+		{"same parameters", func(a *Argon2id) {}, false},
+		{"memory", func(a *Argon2id) { a.Memory *= 2 }, true},
+		{"iterations", func(a *Argon2id) { a.Iterations++ }, true},
+		{"parallelism", func(a *Argon2id) { a.Parallelism++ }, true},
+		{"salt length", func(a *Argon2id) { a.SaltLength = 32 }, true},
+		{"key length", func(a *Argon2id) { a.KeyLength = 64 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher := cheapArgon2id
+			tt.change(&hasher)
+
+			if got := hasher.NeedsRehash(hash); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	hash := mustHash(t, cheapBcrypt, "pa55word")
+
+	if !cheapBcrypt.Identifies(hash) || cheapArgon2id.Identifies(hash) {
+		t.Fatalf("got hash %q identified wrongly", hash)
+	}
+
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if !cheapBcrypt.Identifies([]byte(prefix + "04$")) {
+			t.Errorf("%s: not identified as bcrypt", prefix)
+		}
+	}
+
+	if cheapBcrypt.NeedsRehash(hash) {
+		t.Error("same cost: got NeedsRehash true; want false")
+	}
+
+	if !NewBcrypt().NeedsRehash(hash) {
+		t.Error("higher cost: got NeedsRehash false; want true")
+	}
+
+	// bcrypt ignores everything past 72 bytes, which is why MaxLength stops
+	// longer passwords from being set.
+	long := strings.Repeat("a", cheapBcrypt.MaxLength()+1)
+	if got, err := cheapBcrypt.Matches(long, mustHash(t, cheapBcrypt, long[:cheapBcrypt.MaxLength()])); err != nil || !got {
+		t.Errorf("73-byte password: got %t, %v; want true", got, err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	argon2idHash := mustHash(t, cheapArgon2id, "pa55word")
+	bcryptHash := mustHash(t, cheapBcrypt, "pa55word")
+
+	tests := []struct {
+		name      string
+		plaintext string
+		hash      []byte
+		want      bool
+		wantErr   error
+	}{
+		{"argon2id", "pa55word", argon2idHash, true, nil},
+		{"argon2id wrong password", "wrong", argon2idHash, false, nil},
+		{"legacy bcrypt", "pa55word", bcryptHash, true, nil},
+		{"legacy bcrypt wrong password", "wrong", bcryptHash, false, nil},
+		{"malformed argon2id", "pa55word", []byte("$argon2id$v=19$"), false, ErrInvalidHash},
+		{"unknown algorithm", "pa55word", []byte("$scrypt$ln=15,r=8,p=1$salt$hash"), false, ErrUnknownAlgorithm},
+		{"empty", "pa55word", nil, false, ErrUnknownAlgorithm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Matches(tt.plaintext, tt.hash)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %t, %v; want %t, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash []byte
+		want bool
+	}{
+		{"default parameters", mustHash(t, Default, "pa55word"), false},
+		{"weaker argon2id parameters", mustHash(t, cheapArgon2id, "pa55word"), true},
+		{"legacy bcrypt", mustHash(t, cheapBcrypt, "pa55word"), true},
+		{"unknown algorithm", []byte("$scrypt$"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"argon2id", "bcrypt"} {
+		if _, err := New(name); err != nil {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	if _, err := New("scrypt"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("scrypt: got %v; want %v", err, ErrUnknownAlgorithm)
+	}
+}
@@ -0,0 +1,121 @@
+package passpolicy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BreachedList is a set of passwords known from data breaches, identified by
+// their SHA-1 hashes as published by Have I Been Pwned. It is held in memory,
+// so no network access is needed to check a password against it.
+type BreachedList struct {
+	hashes [][sha1.Size]byte
+}
+
+// LoadBreachedList reads a breached password list in the Have I Been Pwned
+// formats. The path is either a single file with one full SHA-1 hash per line,
+// or a directory of range files, each named after a five character hash prefix
+// and holding the remaining 35 characters of each hash per line. Either way a
+// line may be followed by a colon and a count, which is ignored.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &BreachedList{}
+
+	if !info.IsDir() {
+		err = list.readFile(path, "")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			prefix := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+			if entry.IsDir() || len(prefix) != 5 {
+				continue
+			}
+
+			err = list.readFile(filepath.Join(path, entry.Name()), prefix)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Slice(list.hashes, func(i, j int) bool {
+		return bytes.Compare(list.hashes[i][:], list.hashes[j][:]) < 0
+	})
+
+	return list, nil
+}
+
+func (l *BreachedList) readFile(path, prefix string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return l.read(f, path, prefix)
+}
+
+// read adds the hashes listed in r, each of which is appended to prefix.
+func (l *BreachedList) read(r io.Reader, name, prefix string) error {
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// Check the length before decoding, as hex.Decode doesn't stop at the
+		// end of the hash array.
+		text = prefix + text
+		if len(text) != hex.EncodedLen(sha1.Size) {
+			return fmt.Errorf("%s:%d: invalid SHA-1 hash", name, line)
+		}
+
+		var hash [sha1.Size]byte
+
+		_, err := hex.Decode(hash[:], []byte(text))
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid SHA-1 hash", name, line)
+		}
+
+		l.hashes = append(l.hashes, hash)
+	}
+
+	return scanner.Err()
+}
+
+// Len returns the number of passwords in the list.
+func (l *BreachedList) Len() int {
+	return len(l.hashes)
+}
+
+// Contains reports whether the password is in the list.
+func (l *BreachedList) Contains(password string) bool {
+	hash := sha1.Sum([]byte(password))
+
+	i := sort.Search(len(l.hashes), func(i int) bool {
+		return bytes.Compare(l.hashes[i][:], hash[:]) >= 0
+	})
+
+	return i < len(l.hashes) && l.hashes[i] == hash
+}
@@ -0,0 +1,166 @@
+package passpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hashOf returns the SHA-1 hash of the password in the uppercase hex form the
+// Have I Been Pwned lists use.
+func hashOf(password string) string {
+	hash := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+// newTestList returns a list holding the passwords.
+func newTestList(t *testing.T, passwords ...string) *BreachedList {
+	t.Helper()
+
+	var lines []string
+	for _, password := range passwords {
+		lines = append(lines, hashOf(password))
+	}
+
+	return loadTestFile(t, strings.Join(lines, "\n"))
+}
+
+// loadTestFile writes the content into a temporary file and loads the list
+// from it.
+func loadTestFile(t *testing.T, content string) *BreachedList {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return list
+}
+
+func TestLoadBreachedListFile(t *testing.T) {
+	content := "# a comment\r\n" +
+		hashOf("password") + ":3861493\r\n" +
+		"\r\n" +
+		strings.ToLower(hashOf("123456")) + "\r\n" +
+		"  " + hashOf("qwerty") + ":1  \r\n"
+
+	list := loadTestFile(t, content)
+
+	if list.Len() != 3 {
+		t.Errorf("got %d passwords; want 3", list.Len())
+	}
+
+	for _, password := range []string{"password", "123456", "qwerty"} {
+		if !list.Contains(password) {
+			t.Errorf("%q: not found", password)
+		}
+	}
+
+	for _, password := range []string{"Password", "1234567", ""} {
+		if list.Contains(password) {
+			t.Errorf("%q: found", password)
+		}
+	}
+}
+
+func TestLoadBreachedListRanges(t *testing.T) {
+	files := map[string]string{}
+
+	for _, password := range []string{"password", "123456", "letmein"} {
+		hash := hashOf(password)
+		files[hash[:5]+".txt"] += hash[5:] + ":12\r\n"
+	}
+
+	// Files which aren't named after a hash prefix are skipped.
+	files["README"] = "not a range file"
+
+	dir := t.TempDir()
+	for file, content := range files {
+		err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.Mkdir(filepath.Join(dir, "ABCDE"), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadBreachedList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list.Len() != 3 {
+		t.Errorf("got %d passwords; want 3", list.Len())
+	}
+
+	for _, password := range []string{"password", "123456", "letmein"} {
+		if !list.Contains(password) {
+			t.Errorf("%q: not found", password)
+		}
+	}
+
+	if list.Contains("qwerty") {
+		t.Error(`"qwerty": found`)
+	}
+}
+
+func TestLoadBreachedListInvalid(t *testing.T) {
+	hash := hashOf("password")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{"not hex", "pwned.txt", hash + "\nnot a hash:1\n", "pwned.txt:2: invalid SHA-1 hash"},
+		{"short hash", "pwned.txt", hash[:39] + "\n", "pwned.txt:1: invalid SHA-1 hash"},
+		{"long hash", "pwned.txt", hash + "0\n", "pwned.txt:1: invalid SHA-1 hash"},
+		{"full hash in a range file", hash[:5] + ".txt", hash + ":1\r\n", hash[:5] + ".txt:1: invalid SHA-1 hash"},
+		{"count without a hash", "pwned.txt", ":3\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.content), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Range files are only read as such when loading a directory.
+			path := filepath.Join(dir, tt.file)
+			if tt.file != "pwned.txt" {
+				path = dir
+			}
+
+			_, err = LoadBreachedList(path)
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %v; want none", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadBreachedList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("missing file: got no error")
+	}
+}
@@ -0,0 +1,138 @@
+// Package passpolicy decides whether a password is acceptable, beyond the length
+// limits of the password hasher: it must be strong enough, not known from a data
+// breach, and not made from the user's own details.
+package passpolicy
+
+import (
+	"fmt"
+	"greenlight/internal/validator"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Policy is a set of password rules.
+type Policy struct {
+	// MinEntropy is the lowest estimated strength accepted, in bits. See
+	// Entropy for how it is estimated.
+	MinEntropy float64
+
+	// Breached is the list of passwords known from data breaches. It may be
+	// nil, in which case passwords aren't checked against a list.
+	Breached *BreachedList
+}
+
+// Validate checks the password against the policy, adding a single error under
+// the "password" key which describes every rule the password breaks. The
+// personal values, such as the user's name and email address, must not appear
+// in the password.
+func (p *Policy) Validate(v *validator.Validator, password string, personal ...string) {
+	var problems []string
+
+	if term, ok := containsPersonal(password, personal); ok {
+		problems = append(problems, fmt.Sprintf("must not contain your name or email address (%q)", term))
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		problems = append(problems, "must not be a password that has appeared in a data breach")
+	}
+
+	if entropy := Entropy(password); entropy < p.MinEntropy {
+		problems = append(problems, fmt.Sprintf("is too easy to guess (estimated strength %.0f bits, at least %.0f required)",
+			math.Floor(entropy), p.MinEntropy))
+	}
+
+	if len(problems) > 0 {
+		v.AddError("password", strings.Join(problems, "; "))
+	}
+}
+
+// Entropy estimates the strength of a password in bits, as the number of bits
+// needed to pick each character from the classes of characters it uses:
+// lowercase letters, uppercase letters, digits, ASCII symbols and anything
+// else. A character repeating the previous one or continuing a run such as
+// "abc" or "321" only counts for one bit.
+func Entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+
+	runes := []rune(password)
+
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	bitsPerChar := math.Log2(float64(pool))
+
+	var entropy float64
+	for i, r := range runes {
+		if i > 0 {
+			if diff := r - runes[i-1]; diff >= -1 && diff <= 1 {
+				entropy++
+				continue
+			}
+		}
+		entropy += bitsPerChar
+	}
+
+	return entropy
+}
+
+// containsPersonal returns the first personal term found in the password,
+// ignoring case. Each value is checked whole and word by word. Terms shorter
+// than three characters are ignored.
+func containsPersonal(password string, personal []string) (string, bool) {
+	password = strings.ToLower(password)
+
+	var terms []string
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		terms = append(terms, value)
+
+		// Only the local part of an email address is split into words, so that
+		// a domain like "example.com" doesn't rule out every password with
+		// "com" in it.
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			terms = append(terms, local)
+			value = local
+		}
+
+		terms = append(terms, strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+	}
+
+	for _, term := range terms {
+		if len(term) >= 3 && strings.Contains(password, term) {
+			return term, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,115 @@
+package passpolicy
+
+import (
+	"greenlight/internal/validator"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     float64
+	}{
+		{"empty", "", 0},
+		{"lowercase", "a", math.Log2(26)},
+		{"uppercase", "Q", math.Log2(26)},
+		{"digit", "7", math.Log2(10)},
+		{"symbol", "!", math.Log2(33)},
+		{"space is a symbol", " ", math.Log2(33)},
+		{"tilde is a symbol", "~", math.Log2(33)},
+		{"non-ASCII", "é", math.Log2(100)},
+		{"control character", "\t", math.Log2(100)},
+		{"lowercase and uppercase", "aQ", 2 * math.Log2(52)},
+		{"lowercase and digit", "a7", 2 * math.Log2(36)},
+		{"every class", "aQ7!é", 5 * math.Log2(195)},
+		{"repeated character", "aaaa", math.Log2(26) + 3},
+		{"ascending run", "abcd", math.Log2(26) + 3},
+		{"descending run", "4321", math.Log2(10) + 3},
+		{"gap of two", "ace", 3 * math.Log2(26)},
+		{"run broken and resumed", "abxy", 2*math.Log2(26) + 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Entropy(tt.password); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %f; want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainsPersonal(t *testing.T) {
+	personal := []string{"Alice Smith", "jo.bloggs@example.com"}
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"first name", "xxalicexx", "alice"},
+		{"last name in capitals", "SMITH-2024", "smith"},
+		{"whole name", "alice smith!", "alice smith"},
+		{"whole email address", "jo.bloggs@example.com1", "jo.bloggs@example.com"},
+		{"email local part", "my jo.bloggs pass", "jo.bloggs"},
+		{"word of the email local part", "Bloggs2024!", "bloggs"},
+		{"email domain", "example-password", ""},
+		{"top-level domain", "compassion", ""},
+		{"short word", "jo-jo-jo", ""},
+		{"unrelated", "correct horse battery", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := containsPersonal(tt.password, personal)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("got %q, %t; want %q, %t", got, ok, tt.want, tt.want != "")
+			}
+		})
+	}
+
+	if term, ok := containsPersonal("anything", nil); ok {
+		t.Errorf("no personal values: got %q", term)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const password = "Tr0ub4dor&3"
+
+	list := newTestList(t, "password1")
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		password   string
+		personal   []string
+		wantErrors bool
+	}{
+		{"strong enough", Policy{MinEntropy: Entropy(password)}, password, nil, false},
+		{"just too weak", Policy{MinEntropy: Entropy(password) + 0.01}, password, nil, true},
+		{"breached", Policy{Breached: list}, "password1", nil, true},
+		{"not breached", Policy{Breached: list}, password, nil, false},
+		{"personal", Policy{}, "alice-" + password, []string{"Alice"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			tt.policy.Validate(v, tt.password, tt.personal...)
+
+			if v.Valid() == tt.wantErrors {
+				t.Errorf("got errors %v; want errors %t", v.Errors, tt.wantErrors)
+			}
+		})
+	}
+
+	// Every broken rule is described in the single password error.
+	v := validator.New()
+	(&Policy{MinEntropy: 100, Breached: list}).Validate(v, "password1", "password")
+
+	if got := v.Errors["password"]; len(got) == 0 || strings.Count(got, "; ") != 2 {
+		t.Errorf("got %q; want three problems", got)
+	}
+}
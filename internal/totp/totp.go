@@ -0,0 +1,157 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The parameters used for every code: the RFC 6238 defaults, which are the only
+// ones that all common authenticator apps support.
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	secretSize = 20
+)
+
+var ErrInvalidCiphertext = errors.New("totp: invalid ciphertext")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random shared secret.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, secretSize)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret returns the secret in the base-32 form that users type into an
+// authenticator app.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// ProvisioningURI returns the otpauth:// URI for the secret, which authenticator
+// apps read from a QR code.
+func ProvisioningURI(issuer, account string, secret []byte) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	params := url.Values{}
+	params.Set("secret", EncodeSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step that t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for the secret at the given time step, as described in
+// RFC 4226 section 5.3.
+func Code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// Validate checks a code against the secret, allowing for one time step of
+// clock drift either way. It returns the time step the code matched, which the
+// caller should record so that the same code can't be used twice.
+func Validate(secret []byte, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(now)
+
+	for _, step := range []int64{current, current - 1, current + 1} {
+		if hmac.Equal([]byte(Code(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// Cipher encrypts secrets before they are stored, using AES-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a Cipher using the given 16, 24 or 32-byte AES key.
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt seals the secret with a random nonce, which is prepended to the
+// returned ciphertext.
+func (c *Cipher) Encrypt(secret []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, secret, nil), nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt.
+func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce, sealed := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+
+	secret, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return secret, nil
+}
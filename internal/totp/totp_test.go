@@ -0,0 +1,150 @@
+package totp
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The SHA-1 vectors of RFC 6238 Appendix B. The RFC gives 8-digit codes, of
+	// which a 6-digit code is the last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(rfcSecret, Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("%d: got %q; want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", Code(rfcSecret, current), current, true},
+		{"previous step", Code(rfcSecret, current-1), current - 1, true},
+		{"next step", Code(rfcSecret, current+1), current + 1, true},
+		{"surrounding spaces", " " + Code(rfcSecret, current) + " ", current, true},
+		{"two steps behind", Code(rfcSecret, current-2), 0, false},
+		{"two steps ahead", Code(rfcSecret, current+2), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"too short", Code(rfcSecret, current)[:5], 0, false},
+		{"too long", Code(rfcSecret, current) + "0", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			if step != tt.wantStep || ok != tt.wantOK {
+				t.Errorf("got %d, %t; want %d, %t", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(ProvisioningURI("Greenlight", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Greenlight:alice@example.com" {
+		t.Errorf("got %q; want an otpauth://totp/ URI labelled Greenlight:alice@example.com", uri)
+	}
+
+	if got, want := uri.Query().Get("secret"), "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"; got != want {
+		t.Errorf("got secret %q; want %q", got, want)
+	}
+}
+
+func TestCipher(t *testing.T) {
+	c, err := NewCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := c.Encrypt(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := c.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(secret, rfcSecret) {
+		t.Errorf("got secret %q; want %q", secret, rfcSecret)
+	}
+
+	// Each encryption uses a new nonce.
+	again, err := c.Encrypt(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(again, ciphertext) {
+		t.Error("got the same ciphertext twice")
+	}
+
+	other, err := NewCipher(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamper := func(i int) []byte {
+		b := bytes.Clone(ciphertext)
+		b[i] ^= 1
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		cipher     *Cipher
+		ciphertext []byte
+	}{
+		{"tampered nonce", c, tamper(0)},
+		{"tampered secret", c, tamper(len(ciphertext) / 2)},
+		{"tampered tag", c, tamper(len(ciphertext) - 1)},
+		{"truncated", c, ciphertext[:len(ciphertext)-1]},
+		{"shorter than the nonce", c, ciphertext[:4]},
+		{"empty", c, nil},
+		{"another key", other, ciphertext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := tt.cipher.Decrypt(tt.ciphertext)
+			if !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("got %q, %v; want %v", secret, err, ErrInvalidCiphertext)
+			}
+		})
+	}
+
+	if _, err := NewCipher([]byte("short")); err == nil {
+		t.Error("short key: got no error")
+	}
+}
@@ -0,0 +1,15 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS id text;
+
+UPDATE tokens SET id = md5(random()::text || hash::text) WHERE id IS NULL;
+
+ALTER TABLE tokens ALTER COLUMN id SET NOT NULL;
+
+ALTER TABLE tokens ADD CONSTRAINT tokens_id_key UNIQUE (id);
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS ip text NOT NULL DEFAULT '';
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS user_agent text NOT NULL DEFAULT '';
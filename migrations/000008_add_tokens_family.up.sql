@@ -0,0 +1,9 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family text;
+
+UPDATE tokens SET family = id WHERE family IS NULL;
+
+ALTER TABLE tokens ALTER COLUMN family SET NOT NULL;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
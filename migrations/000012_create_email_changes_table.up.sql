@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS email_changes (
+    hash bytea PRIMARY KEY REFERENCES tokens ON DELETE CASCADE,
+    email citext NOT NULL
+);
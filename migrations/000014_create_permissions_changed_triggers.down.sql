@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS role_permissions_changed ON role_permissions;
+DROP TRIGGER IF EXISTS user_roles_changed ON user_roles;
+DROP TRIGGER IF EXISTS user_permissions_changed ON user_permissions;
+DROP FUNCTION IF EXISTS notify_role_permissions_changed();
+DROP FUNCTION IF EXISTS notify_user_permissions_changed();
@@ -0,0 +1,32 @@
+CREATE OR REPLACE FUNCTION notify_user_permissions_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('permissions_changed', OLD.user_id::text);
+    ELSE
+        PERFORM pg_notify('permissions_changed', NEW.user_id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_role_permissions_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('permissions_changed', '*');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS user_permissions_changed ON user_permissions;
+CREATE TRIGGER user_permissions_changed
+AFTER INSERT OR UPDATE OR DELETE ON user_permissions
+FOR EACH ROW EXECUTE FUNCTION notify_user_permissions_changed();
+
+DROP TRIGGER IF EXISTS user_roles_changed ON user_roles;
+CREATE TRIGGER user_roles_changed
+AFTER INSERT OR UPDATE OR DELETE ON user_roles
+FOR EACH ROW EXECUTE FUNCTION notify_user_permissions_changed();
+
+DROP TRIGGER IF EXISTS role_permissions_changed ON role_permissions;
+CREATE TRIGGER role_permissions_changed
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON role_permissions
+FOR EACH STATEMENT EXECUTE FUNCTION notify_role_permissions_changed();
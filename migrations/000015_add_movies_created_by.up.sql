@@ -0,0 +1,12 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
+
+INSERT INTO permissions (code) VALUES ('movies:admin')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'movies:admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS movies_organization_id_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS active_organizations;
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('viewer', 'editor', 'admin')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id);
+
+CREATE TABLE IF NOT EXISTS organization_invitations (
+    hash bytea PRIMARY KEY,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    email citext NOT NULL,
+    role text NOT NULL CHECK (role IN ('viewer', 'editor', 'admin')),
+    invited_by bigint REFERENCES users ON DELETE SET NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS organization_invitations_organization_id_email_idx
+ON organization_invitations (organization_id, email);
+
+CREATE TABLE IF NOT EXISTS active_organizations (
+    user_id bigint PRIMARY KEY,
+    organization_id bigint NOT NULL,
+    FOREIGN KEY (organization_id, user_id) REFERENCES organization_members ON DELETE CASCADE
+);
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS movies_organization_id_idx ON movies ((COALESCE(organization_id, 0)));
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS invitation_codes (
+    id bigserial PRIMARY KEY,
+    hash bytea UNIQUE NOT NULL,
+    email citext,
+    max_uses integer NOT NULL CHECK (max_uses > 0),
+    uses integer NOT NULL DEFAULT 0 CHECK (uses BETWEEN 0 AND max_uses),
+    permissions text[] NOT NULL,
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_organization_id_title_id_idx;
+
+DROP INDEX IF EXISTS movies_organization_id_year_id_idx;
+
+DROP INDEX IF EXISTS movies_organization_id_runtime_id_idx;
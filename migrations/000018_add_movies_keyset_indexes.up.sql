@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS movies_organization_id_title_id_idx ON movies ((COALESCE(organization_id, 0)), title, id);
+
+CREATE INDEX IF NOT EXISTS movies_organization_id_year_id_idx ON movies ((COALESCE(organization_id, 0)), year, id);
+
+CREATE INDEX IF NOT EXISTS movies_organization_id_runtime_id_idx ON movies ((COALESCE(organization_id, 0)), runtime, id);
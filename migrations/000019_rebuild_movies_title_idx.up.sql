@@ -0,0 +1,6 @@
+-- The index has to be built with the text search configuration given to the
+-- API with -db-search-config. Change 'simple', its default, here before
+-- migrating to use another one.
+DROP INDEX IF EXISTS movies_title_idx;
+
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('simple', title));
@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN (title gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS movies_lower_title_idx ON movies (lower(title));
@@ -0,0 +1,8 @@
+INSERT INTO permissions (code) VALUES ('users:admin')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'users:admin'
+ON CONFLICT DO NOTHING;